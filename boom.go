@@ -20,8 +20,9 @@ import (
 	"os"
 	"os/signal"
 	"time"
-	"math"
 	"context"
+	"io/ioutil"
+	"strings"
 
 	"github.com/heidawei/smartBoom/worker"
 	"github.com/dustin/gojson"
@@ -38,33 +39,196 @@ var (
 	name = flag.String("name", "", "")
 	config = flag.String("config", "", "")
 	p = flag.Bool("pprof", false, "")
+	sigfigs = flag.Int("sigfigs", worker.DefaultSigFigs, "")
+	maxLatency = flag.Duration("max-latency", worker.DefaultMaxLatency, "")
+	percentiles = flag.String("percentiles", "10,25,50,75,90,95,99", "")
+	metricsAddr = flag.String("metrics-addr", "", "")
+	tui = flag.Bool("tui", false, "")
+	logPath = flag.String("log", "", "")
 
 	cpus = flag.Int("cpus", runtime.GOMAXPROCS(-1), "")
+
+	assertIntervals = flag.Bool("assert-intervals", false, "")
+	junit = flag.String("junit", "", "")
+	stages = flag.String("stages", "", "")
+	stagesFile = flag.String("stages-file", "", "")
+	openModel = flag.Bool("open-model", false, "")
+	burst = flag.Int("burst", 1, "")
+	perCellQPS = flag.Bool("per-cell-qps", false, "")
+	batch = flag.Int("batch", 1, "")
+	iterations = flag.Int("iterations", 0, "")
+	maxBytes = flag.Int64("max-bytes", 0, "")
+	findMax = flag.String("find-max", "", "")
+	findRange = flag.String("find-range", "", "")
+	findStep = flag.Float64("find-step", 0, "")
+	findBinary = flag.Bool("find-binary", false, "")
+	findHold = flag.Duration("find-hold", 30*time.Second, "")
+	controlAddr = flag.String("control-addr", "", "")
+	warmup = flag.Duration("warmup", 0, "")
+	think = flag.String("think", "", "")
+	pacing = flag.Duration("pacing", 0, "")
+	timeout = flag.Duration("timeout", 0, "")
+	grace = flag.Duration("grace", 5*time.Second, "")
+	arrival = flag.String("arrival", worker.ArrivalUniform, "")
+
+	outputs stringsFlag
+	asserts stringsFlag
+	aborts  stringsFlag
+	slos    stringsFlag
+)
+
+func init() {
+	flag.Var(&outputs, "output", "")
+	flag.Var(&asserts, "assert", "")
+	flag.Var(&aborts, "abort-if", "")
+	flag.Var(&slos, "slo", "")
+}
+
+// stringsFlag collects the values of a flag given several times.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// Exit codes other than 0.
+const (
+	exitFailure    = 1
+	exitRegression = 2
+	exitAssertion  = 3
+	exitAborted    = 4
 )
 
 var usage = `Usage: smartBoom [options...] <url>
+       smartBoom report [options...] <log>
+       smartBoom compare [options...] <baseline> <result>
 
 Options:
-  -n  Number of requests to run. Default is 200.
+  -n  Number of requests to run. Default is 200, or no limit if -z,
+      -iterations, -max-bytes, -stages or -find-max end the run.
   -c  Number of requests to run concurrently. Total number of requests cannot
       be smaller than the concurrency level. Default is 50.
-  -q  Rate limit, in queries per second (QPS). Default is no rate limit.
+  -q  Rate limit, in queries per second (QPS), of all workers together.
+      Default is no rate limit.
+  -burst         Number of requests that may go at once after an idle
+                 spell without exceeding -q. Default is 1.
+  -per-cell-qps  Limit every worker to -q on its own, so -c 50 -q 100
+                 makes up to 5000 QPS.
+  -batch         Number of requests a worker claims at once, for
+                 executors making several requests per call through
+                 DoBatch. Default is 1.
 
   -i  Interval of collector report, unit second. Default is 1 second.
   -z  Duration of application to send requests. When duration is reached,
-      application stops and exits. If duration is specified, n is ignored.
-      Examples: -z 10s -z 3m.
+      application stops and exits. Examples: -z 10s -z 3m.
+  -iterations  Number of requests every worker makes at most.
+  -max-bytes   Stop once the responses carried this many bytes.
+      The run ends at the first of -n, -z, -iterations and -max-bytes it
+      reaches, the summary tells which.
+  -timeout Deadline of every request, a request lasting longer fails.
+           Default is no deadline.
+  -grace   How long a stopped run waits for the requests in flight before
+           it cancels them, a second interrupt exits at once. Default is 5s.
+  -think   Pause of every worker after each request, constant:D,
+           uniform:MIN-MAX, normal:MEAN,STDDEV or exp:MEAN. It does not
+           count in the latency. Example: -think uniform:50ms-200ms
+  -pacing  Period of the requests of every worker, a worker waits out the
+           rest of the period after each request. Example: -pacing 1s
+  -warmup  Start of the run whose results are written as warmup rows and
+           left out of the summary, the assertions and the stop
+           conditions. It counts in -z and -n. Example: -warmup 30s
+
+  -stages      Load plan of comma separated stages, each holding or ramping
+               the total rate and/or the concurrency for a duration. The
+               run starts with -c cells and ends with the plan, -n is
+               ignored. Example: -stages "30s@100qps, 5m@2000qps/50c,
+               1m ramp to 5000qps, 1m ramp to 100c, 30s@0"
+  -stages-file File with the load plan, one stage per line.
+
+  -open-model  Send requests on a fixed schedule at the target rate, -q or
+               the -stages rate, whether or not the server keeps up.
+               Requests wait for a free cell and their latency counts
+               from when they were due, uncorrected latencies are reported
+               next to them.
+  -arrival     Spacing of the open model requests, uniform or poisson.
+               Default is uniform.
+
+  -find-max    Search the highest qps or cells meeting the -slo thresholds,
+               the run ends with the search.
+               Example: -find-max qps -find-range 100:10000 -find-step 100
+                        -slo "p99<200ms" -slo "errors<1%%"
+  -find-range  Lowest and highest target as low:high.
+  -find-step   Increment of the target, or the resolution with -find-binary.
+  -find-binary Bisect the range instead of stepping up from its low end.
+  -find-hold   How long every target is held, rounded up to whole
+               intervals. Default is 30s.
+  -slo         Threshold a step must meet, may be repeated. It uses the
+               -assert metrics except min, max and stddev, a qps step also
+               fails below 95%% of its target.
 
   -name Name of executor.
   -config Executor config json file.
   -pporf go pprof.
-  
+
+  -sigfigs      Significant digits kept by the latency histogram, 1 to 5.
+                Default is 3.
+  -max-latency  Highest latency tracked by the histogram, larger latencies
+                are recorded as this value. Default is 1m.
+  -percentiles  Comma separated latency percentiles to report, fractions are
+                allowed. Default is 10,25,50,75,90,95,99.
+                Example: -percentiles 50,90,99,99.9,99.99,100.
+
+  -output       Output as format:path, may be repeated. Formats are xlsx,
+                csv, jsonl and html, csv and jsonl write to stdout if path
+                is -. Default is an xlsx file next to the executable.
+                Example: -output xlsx:out.xlsx -output jsonl:-
+                         -output html:report.html
+  -assert       Threshold checked against the summary, may be repeated.
+                Metrics are rps, requests, success, errors, mean, min, max,
+                stddev and pN for any percentile N. The process exits with
+                status 3 if one fails.
+                Example: -assert "p99<200ms" -assert "errors<0.1%%"
+                         -assert "rps>5000"
+  -assert-intervals  Also check the thresholds against every interval,
+                min, max and stddev are only checked against the summary.
+  -abort-if     Stop the run when a condition holds for a number of
+                consecutive intervals (default 1) or for a duration, may be
+                repeated. Conditions use the -assert metrics except min, max
+                and stddev. The process exits with status 4.
+                Example: -abort-if "errors>5%% for 3" -abort-if "p99>500ms"
+                         -abort-if "success==0 for 10s"
+  -junit        Write the assertion results as JUnit XML to this file.
+  -log          Log every result to a gzip compressed JSON lines file, the
+                report command rebuilds any output from it.
+  -tui          Redraw a live dashboard every interval instead of printing
+                lines, ignored when stdout is not a terminal.
+  -metrics-addr Address serving live Prometheus metrics on /metrics.
+                Example: -metrics-addr :9100
+  -control-addr Address serving an HTTP API to steer the run: GET /status,
+                POST /qps?value=N, /cells?value=N, /pause, /resume and
+                /stop. Example: -control-addr localhost:9200
 
   -cpus                 Number of used cpu cores.
                         (default for current machine is %d cores)
 `
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "report":
+			runReport(os.Args[2:])
+			return
+		case "compare":
+			runCompare(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Usage = func() {
 		fmt.Fprint(os.Stderr, fmt.Sprintf(usage, runtime.NumCPU()))
 	}
@@ -79,22 +243,146 @@ func main() {
 	interval := *i
 	dur := *z
 
-	if dur > 0 {
-		if conc <= 0 {
-			usageAndExit("-n and -c cannot be smaller than 1.")
+	var plan []*worker.Stage
+	if len(*stages) > 0 && len(*stagesFile) > 0 {
+		usageAndExit("-stages and -stages-file cannot be used together.")
+	}
+	if len(*stagesFile) > 0 {
+		data, err := ioutil.ReadFile(*stagesFile)
+		if err != nil {
+			usageAndExit(fmt.Sprintf("stages file %s is invalid, err %v", *stagesFile, err))
 		}
-		num = math.MaxInt32
-	} else {
-		if num <= 0 || conc <= 0 {
-			usageAndExit("-n and -c cannot be smaller than 1.")
+		*stages = string(data)
+	}
+	if len(*stages) > 0 {
+		var err error
+		plan, err = worker.ParseStages(*stages)
+		if err != nil {
+			usageAndExit(fmt.Sprintf("-stages is invalid, err %v", err))
+		}
+	}
+
+	var search *worker.Search
+	if len(*findMax) > 0 {
+		if len(plan) > 0 {
+			usageAndExit("-find-max and -stages cannot be used together.")
 		}
+		var slo []*worker.Assertion
+		for _, expr := range slos {
+			a, err := worker.ParseAssertion(expr)
+			if err != nil {
+				usageAndExit(fmt.Sprintf("-slo is invalid, err %v", err))
+			}
+			slo = append(slo, a)
+		}
+		var err error
+		search, err = worker.NewSearch(*findMax, *findRange, *findStep, *findBinary, *findHold, slo)
+		if err != nil {
+			usageAndExit(fmt.Sprintf("-find-max is invalid, err %v", err))
+		}
+	}
+
+	// The run ends at the first limit it reaches. The default -n only
+	// applies if nothing else ends the run.
+	if *iterations < 0 || *maxBytes < 0 {
+		usageAndExit("-iterations and -max-bytes cannot be negative.")
+	}
+	if !isFlagSet("n") && (dur > 0 || *iterations > 0 || *maxBytes > 0 || len(plan) > 0 || search != nil) {
+		num = 0
+	} else if num <= 0 {
+		usageAndExit("-n and -c cannot be smaller than 1.")
+	}
+	if conc <= 0 {
+		usageAndExit("-n and -c cannot be smaller than 1.")
 	}
 
 	if interval <= time.Millisecond {
 		usageAndExit("-i cannot be smaller than 1 ms")
 	}
 
-	if num < conc {
+	length := dur
+	if d := worker.StagesDuration(plan); len(plan) > 0 && (length <= 0 || d < length) {
+		length = d
+	}
+	if *warmup < 0 || (length > 0 && *warmup >= length) {
+		usageAndExit("-warmup must be shorter than the run.")
+	}
+
+	if *burst < 1 {
+		usageAndExit("-burst cannot be smaller than 1.")
+	}
+	if *batch < 1 {
+		usageAndExit("-batch cannot be smaller than 1.")
+	}
+
+	var thinkTime *worker.ThinkTime
+	if len(*think) > 0 {
+		var err error
+		thinkTime, err = worker.ParseThinkTime(*think)
+		if err != nil {
+			usageAndExit(fmt.Sprintf("-think is invalid, err %v", err))
+		}
+	}
+	if *timeout < 0 || *grace < 0 {
+		usageAndExit("-timeout and -grace cannot be negative.")
+	}
+	if *pacing < 0 {
+		usageAndExit("-pacing cannot be negative.")
+	}
+	if thinkTime != nil && *pacing > 0 {
+		usageAndExit("-think and -pacing cannot be used together.")
+	}
+
+	if *openModel {
+		if thinkTime != nil || *pacing > 0 {
+			usageAndExit("-open-model cannot be used with -think or -pacing.")
+		}
+		if q <= 0 && len(plan) == 0 {
+			usageAndExit("-open-model needs a rate, set -q or -stages.")
+		}
+		if *arrival != worker.ArrivalUniform && *arrival != worker.ArrivalPoisson {
+			usageAndExit("-arrival must be uniform or poisson.")
+		}
+	}
+
+	if *sigfigs < 1 || *sigfigs > 5 {
+		usageAndExit("-sigfigs must be between 1 and 5.")
+	}
+
+	if *maxLatency <= time.Millisecond {
+		usageAndExit("-max-latency cannot be smaller than 1 ms")
+	}
+
+	pctls, err := worker.ParsePercentiles(*percentiles)
+	if err != nil {
+		usageAndExit(fmt.Sprintf("-percentiles is invalid, err %v", err))
+	}
+
+	for _, spec := range outputs {
+		if _, err := worker.ParseOutput(spec); err != nil {
+			usageAndExit(fmt.Sprintf("-output %s is invalid, err %v", spec, err))
+		}
+	}
+
+	var assertions []*worker.Assertion
+	for _, expr := range asserts {
+		a, err := worker.ParseAssertion(expr)
+		if err != nil {
+			usageAndExit(fmt.Sprintf("-assert is invalid, err %v", err))
+		}
+		assertions = append(assertions, a)
+	}
+
+	var conditions []*worker.StopCondition
+	for _, expr := range aborts {
+		c, err := worker.ParseStopCondition(expr)
+		if err != nil {
+			usageAndExit(fmt.Sprintf("-abort-if is invalid, err %v", err))
+		}
+		conditions = append(conditions, c)
+	}
+
+	if num > 0 && num < conc {
 		usageAndExit("-n cannot be less than -c.")
 	}
 
@@ -120,8 +408,41 @@ func main() {
 		C:                  conc,
 		QPS:                q,
 		Interval:           interval,
+		Duration:           dur,
+		CellIterations:     *iterations,
+		MaxBytes:           *maxBytes,
 		ExecutorName:       executor,
 		Config:             cfg,
+		SigFigs:            *sigfigs,
+		MaxLatency:         *maxLatency,
+		Percentiles:        pctls,
+		Outputs:            outputs,
+		MetricsAddr:        *metricsAddr,
+		TUI:                *tui,
+		LogPath:            *logPath,
+		Assertions:         assertions,
+		AssertIntervals:    *assertIntervals,
+		JUnitPath:          *junit,
+		StopConditions:     conditions,
+		Stages:             plan,
+		OpenModel:          *openModel,
+		Burst:              *burst,
+		PerCellQPS:         *perCellQPS,
+		Batch:              *batch,
+		Arrival:            *arrival,
+		Search:             search,
+		ControlAddr:        *controlAddr,
+		Warmup:             *warmup,
+		ThinkTime:          thinkTime,
+		Pacing:             *pacing,
+		Timeout:            *timeout,
+		Grace:              *grace,
+	}
+	for _, spec := range outputs {
+		// Keep stdout clean for the machine readable output.
+		if strings.HasSuffix(spec, ":-") {
+			w.Writer = os.Stderr
+		}
 	}
     ctx, cancel := context.WithCancel(context.Background())
     var wg sync.WaitGroup
@@ -172,36 +493,52 @@ func main() {
 	signal.Notify(c, os.Interrupt)
 	go func() {
 		<-c
+		go func() {
+			<-c
+			os.Exit(exitFailure)
+		}()
 		w.Stop()
 		cancel()
 		wg.Wait()
 	}()
-	if dur > 0 {
-		go func() {
-			time.Sleep(dur)
-			w.Stop()
-			cancel()
-			wg.Wait()
-		}()
-	}
-	w.Run()
+	err = w.Run()
 	cancel()
 	wg.Wait()
+	if err == worker.ErrAborted {
+		os.Exit(exitAborted)
+	}
+	if err == worker.ErrAssertionFailed {
+		os.Exit(exitAssertion)
+	}
+	if err != nil {
+		errAndExit(err.Error())
+	}
+}
+
+// isFlagSet reports whether the flag name was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
 
 func errAndExit(msg string) {
-	fmt.Fprintf(os.Stderr, msg)
+	fmt.Fprint(os.Stderr, msg)
 	fmt.Fprintf(os.Stderr, "\n")
-	os.Exit(1)
+	os.Exit(exitFailure)
 }
 
 func usageAndExit(msg string) {
 	if msg != "" {
-		fmt.Fprintf(os.Stderr, msg)
+		fmt.Fprint(os.Stderr, msg)
 		fmt.Fprintf(os.Stderr, "\n\n")
 	}
 	flag.Usage()
 	fmt.Fprintf(os.Stderr, "\n")
-	os.Exit(1)
+	os.Exit(exitFailure)
 }
 
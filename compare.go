@@ -0,0 +1,77 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/heidawei/smartBoom/worker"
+)
+
+var compareUsage = `Usage: smartBoom compare [options...] <baseline> <result>
+
+Compares two runs written with -output jsonl:path and exits with status 2
+if the result regressed beyond the tolerances.
+
+Options:
+  -max-rps-drop          Allowed throughput decrease in percent. Default is 5.
+  -max-error-increase    Allowed error rate increase in percentage points.
+                         Default is 0.1.
+  -max-latency-increase  Allowed increase of the mean and of every percentile
+                         in percent. Default is 10.
+`
+
+func runCompare(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, compareUsage)
+	}
+	var t worker.Tolerance
+	fs.Float64Var(&t.RPSDrop, "max-rps-drop", 5, "")
+	fs.Float64Var(&t.ErrorIncrease, "max-error-increase", 0.1, "")
+	fs.Float64Var(&t.LatencyIncrease, "max-latency-increase", 10, "")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitFailure)
+	}
+	code, err := compareRuns(os.Stdout, fs.Arg(0), fs.Arg(1), t)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	os.Exit(code)
+}
+
+// compareRuns prints the comparison of the runs in the jsonl files and
+// returns the exit status, exitRegression if the result regressed.
+func compareRuns(out io.Writer, basePath, resultPath string, t worker.Tolerance) (int, error) {
+	base, err := worker.LoadRunResult(basePath)
+	if err != nil {
+		return exitFailure, err
+	}
+	result, err := worker.LoadRunResult(resultPath)
+	if err != nil {
+		return exitFailure, err
+	}
+	ds := worker.Compare(base, result, t)
+	worker.PrintDeltas(out, ds)
+	if worker.Regressed(ds) {
+		return exitRegression, nil
+	}
+	return 0, nil
+}
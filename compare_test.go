@@ -0,0 +1,59 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/heidawei/smartBoom/worker"
+)
+
+func TestCompareRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, summary string) string {
+		p := path.Join(dir, name)
+		data := `{"type":"info","n":100}` + "\n" + `{"type":"summary",` + summary + "}\n"
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	base := write("base.jsonl", `"rps":100,"success":100,"mean":0.01`)
+	same := write("same.jsonl", `"rps":99,"success":100,"mean":0.0105`)
+	slow := write("slow.jsonl", `"rps":80,"success":100,"mean":0.01`)
+	tol := worker.Tolerance{RPSDrop: 5, ErrorIncrease: 0.1, LatencyIncrease: 10}
+	tests := []struct {
+		name   string
+		result string
+		code   int
+		err    bool
+	}{
+		{"within the tolerances", same, 0, false},
+		{"regressed", slow, exitRegression, false},
+		{"missing result", path.Join(dir, "none.jsonl"), exitFailure, true},
+	}
+	for _, tt := range tests {
+		code, err := compareRuns(ioutil.Discard, base, tt.result, tol)
+		if code != tt.code || (err != nil) != tt.err {
+			t.Errorf("%s: exit status %d, err %v, want %d", tt.name, code, err, tt.code)
+		}
+	}
+}
@@ -14,6 +14,7 @@
 package executor
 
 import (
+	"context"
 	"time"
 	"sync"
 )
@@ -25,14 +26,104 @@ type Result struct {
 	ContentLength int64
 	// default 1
 	Count         int
+	// Start is set by the worker to the time Do was called, or to the
+	// intended start of the request in the open model.
+	Start         time.Time
+	// Delay is how long the request waited past its intended start in the
+	// open model, Duration includes it.
+	Delay         time.Duration
 }
 
 type Executor interface {
 	Init()
-	// return num of message do
+	// Do makes the request index of the n requests of the run for the
+	// cell base, n is math.MaxInt32 if the run is not bounded by a request
+	// count. Indices are unique across the cells of the run.
 	Do(base, index, n int) *Result
 }
 
+// Closer is implemented by executors holding resources like connections or
+// files, the worker closes the executor of a cell once the cell is done.
+type Closer interface {
+	Close() error
+}
+
+// ContextExecutor is an Executor whose requests can be cancelled,
+// DoContext must return soon after ctx is done.
+type ContextExecutor interface {
+	Init()
+	DoContext(ctx context.Context, base, index, n int) *Result
+}
+
+// BatchExecutor is implemented by executors making several requests per
+// call, the worker calls DoBatch instead of Do and DoContext. The cell has
+// claimed the indices [index, end), DoBatch makes at most end-index
+// requests and returns their number in Count.
+type BatchExecutor interface {
+	Init()
+	DoBatch(ctx context.Context, base, index, end, n int) *Result
+}
+
+// WithContext returns e as a ContextExecutor. An executor without
+// DoContext cannot be cancelled: once ctx is done DoContext returns the
+// error of ctx and leaves Do to finish in the background. The next call
+// waits for it first, so the executor never runs two requests at once,
+// and Close is deferred until it returns.
+func WithContext(e Executor) ContextExecutor {
+	if ce, ok := e.(ContextExecutor); ok {
+		return ce
+	}
+	return &contextAdapter{Executor: e}
+}
+
+type contextAdapter struct {
+	Executor
+	// pending delivers the result of a Do left running by a done ctx.
+	pending chan *Result
+}
+
+func (a *contextAdapter) DoContext(ctx context.Context, base, index, n int) *Result {
+	start := time.Now()
+	if a.pending != nil {
+		select {
+		case <-a.pending:
+			a.pending = nil
+		case <-ctx.Done():
+			return &Result{Err: ctx.Err(), Duration: time.Since(start), Count: 1}
+		}
+	}
+	if ctx.Done() == nil {
+		return a.Do(base, index, n)
+	}
+	ch := make(chan *Result, 1)
+	go func() {
+		ch <- a.Do(base, index, n)
+	}()
+	select {
+	case res := <-ch:
+		return res
+	case <-ctx.Done():
+		a.pending = ch
+		return &Result{Err: ctx.Err(), Duration: time.Since(start), Count: 1}
+	}
+}
+
+// Close closes the executor if it is a Closer, after the Do left running
+// returned.
+func (a *contextAdapter) Close() error {
+	c, ok := a.Executor.(Closer)
+	if !ok {
+		return nil
+	}
+	if a.pending == nil {
+		return c.Close()
+	}
+	go func(pending chan *Result) {
+		<-pending
+		c.Close()
+	}(a.pending)
+	return nil
+}
 
 var resultsPool = &sync.Pool{
 	New: func() interface{} {
@@ -14,6 +14,7 @@
 package httpE
 
 import (
+	"context"
 	"net/http"
 	"bytes"
 	"io/ioutil"
@@ -24,6 +25,7 @@ import (
 	"io"
 	"os"
 	"fmt"
+	"math/rand"
 	"strings"
 	gourl "net/url"
 
@@ -41,6 +43,22 @@ type HttpE struct {
 	request *http.Request
 	config  map[string]interface{}
 	body   []byte
+
+	// url, headers and bodyT are the templates rendered for every
+	// request, nil or empty if they are static, see template.
+	url     *template
+	headers []headerTemplate
+	bodyT   *template
+	vars    vars
+	// buf is reused to render the url and the headers, bodies get their
+	// own buffer as the transport may read them after Do returns.
+	buf     []byte
+	bodyCap int
+}
+
+type headerTemplate struct {
+	name  string
+	value *template
 }
 
 func New(config map[string]interface{}) executor.Executor {
@@ -94,9 +112,24 @@ func New(config map[string]interface{}) executor.Executor {
 func(h *HttpE)Init() {
 	var url, contentType, accept, method string
 	var bodyAll []byte
+	var data *dataFile
+	headers := make(map[string]string)
 	contentType = "text/html"
 	method = "GET"
 	if h.config != nil {
+		if d, ok := h.config["data"]; ok {
+			var err error
+			data, err = loadDataFile(d.(string))
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
+			}
+		}
+		if hs, ok := h.config["headers"]; ok {
+			for k, v := range hs.(map[string]interface{}) {
+				headers[k] = v.(string)
+			}
+		}
 		if u, ok := h.config["url"]; ok {
 			url = u.(string)
 		} else {
@@ -119,11 +152,35 @@ func(h *HttpE)Init() {
 	}
 
 	// set content-type
-	header := make(http.Header)
-	header.Set("Content-Type", contentType)
+	headers["Content-Type"] = contentType
 
 	if accept != "" {
-		header.Set("Accept", accept)
+		headers["Accept"] = accept
+	}
+
+	h.vars = vars{rand: rand.New(rand.NewSource(time.Now().UnixNano())), data: data}
+	h.url = h.parse(url, data)
+	h.bodyT = h.parse(string(bodyAll), data)
+	if !h.url.static {
+		// Rendered again for every request, the first one checks it.
+		url = string(h.url.render(nil, &h.vars))
+	} else {
+		url = h.url.String()
+		h.url = nil
+	}
+	if h.bodyT.static {
+		h.body = []byte(h.bodyT.String())
+		bodyAll = h.body
+		h.bodyT = nil
+	}
+	header := make(http.Header)
+	for name, value := range headers {
+		t := h.parse(value, data)
+		if t.static {
+			header.Set(name, t.String())
+		} else {
+			h.headers = append(h.headers, headerTemplate{name: name, value: t})
+		}
 	}
 
 	req, err := http.NewRequest(method, url, nil)
@@ -136,17 +193,35 @@ func(h *HttpE)Init() {
 	return
 }
 
+func(h *HttpE)parse(text string, data *dataFile) *template {
+	t, err := parseTemplate(text, data)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	return t
+}
+
 // return num of message do
 func(h *HttpE)Do(base, index, n int) *executor.Result {
+	return h.DoContext(context.Background(), base, index, n)
+}
+
+// DoContext sends the request with ctx, which cancels it when done.
+func(h *HttpE)DoContext(ctx context.Context, base, index, n int) *executor.Result {
 	s := now()
 	var size int64
 	var code int
-	req := cloneRequest(h.request, h.body)
-	resp, err := h.cli.Do(req)
+	req, err := h.newRequest(base, index, n)
+	var resp *http.Response
+	if err == nil {
+		resp, err = h.cli.Do(req.WithContext(ctx))
+	}
 	if err == nil {
-		size = resp.ContentLength
 		code = resp.StatusCode
-		io.Copy(ioutil.Discard, resp.Body)
+		// A timeout or a cancel while reading the body fails the request.
+		// The bytes read count, ContentLength is -1 for chunked bodies.
+		size, err = io.Copy(ioutil.Discard, resp.Body)
 		resp.Body.Close()
 	}
 	t := now()
@@ -160,6 +235,44 @@ func(h *HttpE)Do(base, index, n int) *executor.Result {
 	}
 }
 
+// newRequest returns the request to send, rendering the templates for
+// base, index and n.
+func(h *HttpE)newRequest(base, index, n int) (*http.Request, error) {
+	if h.url == nil && h.bodyT == nil && len(h.headers) == 0 {
+		return cloneRequest(h.request, h.body), nil
+	}
+	v := &h.vars
+	v.cell, v.index, v.n, v.now = base, index, n, time.Now()
+	defer func() { v.counter++ }()
+	req := cloneRequest(h.request, h.body)
+	if h.url != nil {
+		h.buf = h.url.render(h.buf[:0], v)
+		u, err := gourl.Parse(string(h.buf))
+		if err != nil {
+			return nil, err
+		}
+		req.URL = u
+		req.Host = u.Host
+	}
+	for _, hd := range h.headers {
+		h.buf = hd.value.render(h.buf[:0], v)
+		req.Header.Set(hd.name, string(h.buf))
+	}
+	if h.bodyT != nil {
+		body := h.bodyT.render(make([]byte, 0, h.bodyCap), v)
+		h.bodyCap = len(body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	return req, nil
+}
+
+// Close closes the idle connections of the client.
+func(h *HttpE)Close() error {
+	h.cli.CloseIdleConnections()
+	return nil
+}
+
 func cloneRequest(r *http.Request, body []byte) *http.Request {
 	// shallow copy of the struct
 	r2 := new(http.Request)
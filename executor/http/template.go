@@ -0,0 +1,317 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package httpE
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Placeholders of a template, written as ${name} or ${name:arg}:
+//
+//	${cell}             id of the worker making the request
+//	${index}            index of the request in the run, unique across workers
+//	${n}                requests of the run, 2147483647 if it has no count
+//	${counter}          requests made by the worker so far, from 0
+//	${int:MIN-MAX}      random integer in [MIN, MAX]
+//	${string:LEN}       random alphanumeric string of LEN characters
+//	${uuid}             random version 4 UUID
+//	${timestamp}        unix time in seconds, ${timestamp:ms}, :us or :ns
+//	                    for finer units
+//	${time:LAYOUT}      current time in a Go layout like 2006-01-02
+//	${data:COLUMN}      column of the data file, row index modulo the rows
+//
+// $${ is a literal ${. The url, the body and the values of the "headers"
+// map of the config are templates, "data" is the path of the data file,
+// a CSV file whose first row names the columns. See http.json.
+const (
+	partLiteral = iota
+	partCell
+	partIndex
+	partN
+	partCounter
+	partInt
+	partString
+	partUUID
+	partTimestamp
+	partTime
+	partData
+)
+
+const alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+type part struct {
+	kind int
+	// lit is the literal text or the time layout.
+	lit string
+	// a and b are the bounds of int, the length of string, the unit of
+	// timestamp in nanoseconds or the column of data.
+	a, b int64
+}
+
+// template is a parsed text with placeholders, rendering appends to a
+// buffer and allocates nothing but the data of the caller.
+type template struct {
+	parts []part
+	// static is set if the text has no placeholders.
+	static bool
+}
+
+// vars are the values of the placeholders for one request.
+type vars struct {
+	cell, index, n, counter int
+	now                     time.Time
+	rand                    *rand.Rand
+	data                    *dataFile
+}
+
+func parseTemplate(text string, data *dataFile) (*template, error) {
+	t := &template{}
+	var lit []byte
+	for len(text) > 0 {
+		i := strings.Index(text, "${")
+		if i < 0 {
+			lit = append(lit, text...)
+			break
+		}
+		if i > 0 && text[i-1] == '$' {
+			lit = append(lit, text[:i-1]...)
+			lit = append(lit, "${"...)
+			text = text[i+2:]
+			continue
+		}
+		lit = append(lit, text[:i]...)
+		end := strings.Index(text[i:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in %q", text)
+		}
+		p, err := parsePart(text[i+2:i+end], data)
+		if err != nil {
+			return nil, err
+		}
+		if len(lit) > 0 {
+			t.parts = append(t.parts, part{kind: partLiteral, lit: string(lit)})
+			lit = lit[:0]
+		}
+		t.parts = append(t.parts, p)
+		text = text[i+end+1:]
+	}
+	if len(lit) > 0 {
+		t.parts = append(t.parts, part{kind: partLiteral, lit: string(lit)})
+	}
+	t.static = len(t.parts) == 0 || (len(t.parts) == 1 && t.parts[0].kind == partLiteral)
+	return t, nil
+}
+
+func parsePart(expr string, data *dataFile) (part, error) {
+	name, arg := expr, ""
+	if i := strings.Index(expr, ":"); i >= 0 {
+		name, arg = expr[:i], expr[i+1:]
+	}
+	p := part{}
+	var err error
+	switch name {
+	case "cell":
+		p.kind = partCell
+	case "index":
+		p.kind = partIndex
+	case "n":
+		p.kind = partN
+	case "counter":
+		p.kind = partCounter
+	case "uuid":
+		p.kind = partUUID
+	case "int":
+		p.kind = partInt
+		// MIN may be negative, the separator comes after its first byte.
+		i := -1
+		if len(arg) > 0 {
+			i = strings.Index(arg[1:], "-")
+		}
+		if i < 0 {
+			return p, fmt.Errorf("placeholder %q is not like int:MIN-MAX", expr)
+		}
+		if p.a, err = strconv.ParseInt(arg[:i+1], 10, 64); err == nil {
+			p.b, err = strconv.ParseInt(arg[i+2:], 10, 64)
+		}
+		if err == nil && p.b < p.a {
+			err = fmt.Errorf("max is below min")
+		}
+	case "string":
+		p.kind = partString
+		p.a, err = strconv.ParseInt(arg, 10, 64)
+		if err == nil && p.a <= 0 {
+			err = fmt.Errorf("length must be positive")
+		}
+	case "timestamp":
+		p.kind = partTimestamp
+		switch arg {
+		case "", "s":
+			p.a = int64(time.Second)
+		case "ms":
+			p.a = int64(time.Millisecond)
+		case "us":
+			p.a = int64(time.Microsecond)
+		case "ns":
+			p.a = 1
+		default:
+			err = fmt.Errorf("unknown unit %q, want s, ms, us or ns", arg)
+		}
+	case "time":
+		p.kind = partTime
+		p.lit = arg
+		if len(arg) == 0 {
+			p.lit = time.RFC3339
+		}
+	case "data":
+		p.kind = partData
+		if data == nil {
+			return p, fmt.Errorf("placeholder %q needs a data file", expr)
+		}
+		col, ok := data.columns[arg]
+		if !ok {
+			return p, fmt.Errorf("data file %s has no column %q", data.path, arg)
+		}
+		p.a = int64(col)
+	default:
+		return p, fmt.Errorf("unknown placeholder %q", expr)
+	}
+	if err != nil {
+		return p, fmt.Errorf("placeholder %q is invalid, err %v", expr, err)
+	}
+	return p, nil
+}
+
+// render appends the text for v to dst.
+func (t *template) render(dst []byte, v *vars) []byte {
+	for i := range t.parts {
+		p := &t.parts[i]
+		switch p.kind {
+		case partLiteral:
+			dst = append(dst, p.lit...)
+		case partCell:
+			dst = strconv.AppendInt(dst, int64(v.cell), 10)
+		case partIndex:
+			dst = strconv.AppendInt(dst, int64(v.index), 10)
+		case partN:
+			dst = strconv.AppendInt(dst, int64(v.n), 10)
+		case partCounter:
+			dst = strconv.AppendInt(dst, int64(v.counter), 10)
+		case partInt:
+			dst = strconv.AppendInt(dst, randRange(v.rand, p.a, p.b), 10)
+		case partString:
+			for j := int64(0); j < p.a; j++ {
+				dst = append(dst, alphanumeric[v.rand.Intn(len(alphanumeric))])
+			}
+		case partUUID:
+			dst = appendUUID(dst, v.rand)
+		case partTimestamp:
+			dst = strconv.AppendInt(dst, v.now.UnixNano()/p.a, 10)
+		case partTime:
+			dst = v.now.AppendFormat(dst, p.lit)
+		case partData:
+			row := v.data.rows[v.index%len(v.data.rows)]
+			dst = append(dst, row[p.a]...)
+		}
+	}
+	return dst
+}
+
+// randRange returns a random integer in [a, b], the width of the range
+// may not fit an int64.
+func randRange(r *rand.Rand, a, b int64) int64 {
+	span := uint64(b) - uint64(a)
+	switch {
+	case span < math.MaxInt64:
+		return a + r.Int63n(int64(span)+1)
+	case span == math.MaxUint64:
+		return int64(r.Uint64())
+	}
+	// Draw below the largest multiple of the range to stay uniform.
+	limit := math.MaxUint64 - math.MaxUint64%(span+1)
+	for {
+		if u := r.Uint64(); u < limit {
+			return int64(uint64(a) + u%(span+1))
+		}
+	}
+}
+
+// String returns the text of a static template.
+func (t *template) String() string {
+	if len(t.parts) == 0 {
+		return ""
+	}
+	return t.parts[0].lit
+}
+
+func appendUUID(dst []byte, r *rand.Rand) []byte {
+	const hex = "0123456789abcdef"
+	var u [16]byte
+	r.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	for i, c := range u {
+		if i == 4 || i == 6 || i == 8 || i == 10 {
+			dst = append(dst, '-')
+		}
+		dst = append(dst, hex[c>>4], hex[c&0x0f])
+	}
+	return dst
+}
+
+// dataFile is a CSV file whose first row names the columns, it is read
+// once and shared by all workers.
+type dataFile struct {
+	path    string
+	columns map[string]int
+	rows    [][]string
+}
+
+var (
+	dataMu    sync.Mutex
+	dataFiles = make(map[string]*dataFile)
+)
+
+func loadDataFile(path string) (*dataFile, error) {
+	dataMu.Lock()
+	defer dataMu.Unlock()
+	if d, ok := dataFiles[path]; ok {
+		return d, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("data file %s is invalid, err %v", path, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("data file %s has no rows after the header", path)
+	}
+	d := &dataFile{path: path, columns: make(map[string]int), rows: records[1:]}
+	for i, name := range records[0] {
+		d.columns[strings.TrimSpace(name)] = i
+	}
+	dataFiles[path] = d
+	return d, nil
+}
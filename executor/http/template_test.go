@@ -0,0 +1,161 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package httpE
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testData(t *testing.T) *dataFile {
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "users.csv")
+	if err := ioutil.WriteFile(p, []byte("user, pass\nalice,a1\nbob,b2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := loadDataFile(p)
+	if err != nil {
+		t.Fatalf("loadDataFile failed, err %v", err)
+	}
+	return d
+}
+
+func TestParseTemplate(t *testing.T) {
+	data := testData(t)
+	tests := []struct {
+		text   string
+		static bool
+		err    bool
+	}{
+		{text: "", static: true},
+		{text: "http://host/path", static: true},
+		{text: "price $5 and $${literal}", static: true},
+		{text: "/u/${index}", static: false},
+		{text: "${cell}${n}${counter}${uuid}", static: false},
+		{text: "${int:1-6} ${string:8} ${timestamp:ms} ${time:2006}", static: false},
+		{text: "${data:user}", static: false},
+		{text: "${index", err: true},
+		{text: "${nope}", err: true},
+		{text: "${int:6-1}", err: true},
+		{text: "${int:6}", err: true},
+		{text: "${int:0-9223372036854775808}", err: true},
+		{text: "${string:0}", err: true},
+		{text: "${timestamp:h}", err: true},
+		{text: "${data:email}", err: true},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseTemplate(tt.text, data)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseTemplate(%q) did not fail", tt.text)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTemplate(%q) failed, err %v", tt.text, err)
+			continue
+		}
+		if tmpl.static != tt.static {
+			t.Errorf("parseTemplate(%q) static = %v, want %v", tt.text, tmpl.static, tt.static)
+		}
+	}
+	if _, err := parseTemplate("${data:user}", nil); err == nil {
+		t.Errorf("parseTemplate of a data column without a data file did not fail")
+	}
+}
+
+func TestRender(t *testing.T) {
+	data := testData(t)
+	now := time.Date(2018, 3, 4, 5, 6, 7, 8000000, time.UTC)
+	v := &vars{cell: 3, index: 41, n: 50, counter: 7, now: now, rand: rand.New(rand.NewSource(1)), data: data}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"http://host/path", "http://host/path"},
+		{"$${index}", "${index}"},
+		{"/u/${index}?c=${cell}&n=${n}&k=${counter}", "/u/41?c=3&n=50&k=7"},
+		{"${timestamp}", strconv.FormatInt(now.Unix(), 10)},
+		{"${timestamp:ms}", strconv.FormatInt(now.UnixNano()/1e6, 10)},
+		{"${timestamp:ns}", strconv.FormatInt(now.UnixNano(), 10)},
+		{"${time:2006-01-02}", "2018-03-04"},
+		{"${time}", "2018-03-04T05:06:07Z"},
+		// Row 41 of two rows is the second one.
+		{"${data:user}:${data:pass}", "bob:b2"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseTemplate(tt.text, data)
+		if err != nil {
+			t.Fatalf("parseTemplate(%q) failed, err %v", tt.text, err)
+		}
+		if got := string(tmpl.render(nil, v)); got != tt.want {
+			t.Errorf("render(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRenderRandom(t *testing.T) {
+	v := &vars{rand: rand.New(rand.NewSource(1))}
+	tests := []struct {
+		text string
+		re   *regexp.Regexp
+	}{
+		{"${int:1-6}", regexp.MustCompile(`^[1-6]$`)},
+		{"${int:-5--3}", regexp.MustCompile(`^-[345]$`)},
+		{"${int:0-9223372036854775807}", regexp.MustCompile(`^[0-9]+$`)},
+		{"${int:-9223372036854775808-0}", regexp.MustCompile(`^(0|-[0-9]+)$`)},
+		{"${int:-9223372036854775808-9223372036854775807}", regexp.MustCompile(`^-?[0-9]+$`)},
+		{"${string:8}", regexp.MustCompile(`^[a-zA-Z0-9]{8}$`)},
+		{"${uuid}", regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseTemplate(tt.text, nil)
+		if err != nil {
+			t.Fatalf("parseTemplate(%q) failed, err %v", tt.text, err)
+		}
+		var buf []byte
+		for i := 0; i < 100; i++ {
+			buf = tmpl.render(buf[:0], v)
+			if !tt.re.Match(buf) {
+				t.Fatalf("render(%q) = %q, want a match of %s", tt.text, buf, tt.re)
+			}
+		}
+	}
+}
+
+func TestRenderAllocs(t *testing.T) {
+	tmpl, err := parseTemplate("/u/${index}?c=${cell}&r=${int:1-100}&id=${uuid}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &vars{rand: rand.New(rand.NewSource(1)), now: time.Now()}
+	buf := tmpl.render(nil, v)
+	allocs := testing.AllocsPerRun(100, func() {
+		v.index++
+		buf = tmpl.render(buf[:0], v)
+	})
+	if allocs > 0 {
+		t.Errorf("render allocated %v times into a reused buffer, want 0", allocs)
+	}
+}
@@ -20,9 +20,14 @@ import (
 
 type CreateExecutor func(config map[string]interface{}) executor.Executor
 
+// Hook runs once per run with the executor config.
+type Hook func(config map[string]interface{}) error
+
 type Register struct {
 	sync.RWMutex
 	cache    map[string]CreateExecutor
+	setups    map[string]Hook
+	teardowns map[string]Hook
 }
 
 var register *Register
@@ -46,6 +51,45 @@ func GetExecutor(name string) (CreateExecutor, bool) {
 	return nil, false
 }
 
+// RegisterSetup registers a hook run before the cells of the executor
+// start, e.g. to create a schema or preload data. The run fails if it does.
+func RegisterSetup(name string, setup Hook) bool {
+	register.Lock()
+	defer register.Unlock()
+	if _, found := register.setups[name]; found {
+		return false
+	}
+	register.setups[name] = setup
+	return true
+}
+
+// RegisterTeardown registers a hook run after the cells of the executor
+// are closed, also if the run failed after the setup.
+func RegisterTeardown(name string, teardown Hook) bool {
+	register.Lock()
+	defer register.Unlock()
+	if _, found := register.teardowns[name]; found {
+		return false
+	}
+	register.teardowns[name] = teardown
+	return true
+}
+
+func GetSetup(name string) (Hook, bool) {
+	register.RLock()
+	defer register.RUnlock()
+	h, found := register.setups[name]
+	return h, found
+}
+
+func GetTeardown(name string) (Hook, bool) {
+	register.RLock()
+	defer register.RUnlock()
+	h, found := register.teardowns[name]
+	return h, found
+}
+
 func init() {
-	register = &Register{cache: make(map[string]CreateExecutor)}
+	register = &Register{cache: make(map[string]CreateExecutor), setups: make(map[string]Hook),
+		teardowns: make(map[string]Hook)}
 }
@@ -0,0 +1,88 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/heidawei/smartBoom/worker"
+)
+
+var reportUsage = `Usage: smartBoom report [options...] <log>
+
+Rebuilds the outputs of a run from the result log written with -log.
+
+Options:
+  -i            Width of the rebuilt intervals. Default is the interval of
+                the run.
+  -percentiles  Comma separated latency percentiles to report.
+                Default is 10,25,50,75,90,95,99.
+  -sigfigs      Significant digits kept by the latency histogram, 1 to 5.
+                Default is 3.
+  -max-latency  Highest latency tracked by the histogram. Default is 1m.
+  -output       Output as format:path, may be repeated. Formats are xlsx,
+                csv, jsonl and html. Default is the console only.
+`
+
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, reportUsage)
+	}
+	interval := fs.Duration("i", 0, "")
+	percentiles := fs.String("percentiles", "10,25,50,75,90,95,99", "")
+	sigfigs := fs.Int("sigfigs", worker.DefaultSigFigs, "")
+	maxLatency := fs.Duration("max-latency", worker.DefaultMaxLatency, "")
+	var outputs stringsFlag
+	fs.Var(&outputs, "output", "")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitFailure)
+	}
+	if *interval != 0 && *interval <= time.Millisecond {
+		errAndExit("-i cannot be smaller than 1 ms")
+	}
+	if *sigfigs < 1 || *sigfigs > 5 {
+		errAndExit("-sigfigs must be between 1 and 5.")
+	}
+	if *maxLatency <= time.Millisecond {
+		errAndExit("-max-latency cannot be smaller than 1 ms")
+	}
+	pctls, err := worker.ParsePercentiles(*percentiles)
+	if err != nil {
+		errAndExit(fmt.Sprintf("-percentiles is invalid, err %v", err))
+	}
+	r := &worker.Report{
+		Path:        fs.Arg(0),
+		Interval:    *interval,
+		SigFigs:     *sigfigs,
+		MaxLatency:  *maxLatency,
+		Percentiles: pctls,
+		Outputs:     outputs,
+	}
+	for _, spec := range outputs {
+		if strings.HasSuffix(spec, ":-") {
+			r.Writer = os.Stderr
+		}
+	}
+	if err := r.Run(); err != nil {
+		errAndExit(err.Error())
+	}
+}
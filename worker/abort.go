@@ -0,0 +1,79 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrAborted is returned by Worker.Run when a stop condition ended the run.
+var ErrAborted = errors.New("run aborted")
+
+// StopCondition aborts a run when its expression holds for Intervals
+// consecutive intervals, or for at least Duration if it is set, e.g.
+// "errors>5% for 3", "p99>500ms" or "success==0 for 10s".
+type StopCondition struct {
+	*Assertion
+	Intervals int
+	Duration  time.Duration
+
+	hits int
+	held time.Duration
+}
+
+func ParseStopCondition(expr string) (*StopCondition, error) {
+	c := &StopCondition{Intervals: 1}
+	cond := expr
+	if i := strings.Index(expr, " for "); i >= 0 {
+		cond = expr[:i]
+		span := strings.TrimSpace(expr[i+len(" for "):])
+		if n, err := strconv.Atoi(span); err == nil && n > 0 {
+			c.Intervals = n
+		} else if d, err := time.ParseDuration(span); err == nil && d > 0 {
+			c.Duration = d
+		} else {
+			return nil, fmt.Errorf("stop condition %q has an invalid span %q", expr, span)
+		}
+	}
+	a, err := ParseAssertion(cond)
+	if err != nil {
+		return nil, err
+	}
+	if !a.perInterval() {
+		return nil, fmt.Errorf("stop condition %q uses %s which intervals do not carry", expr, a.Metric)
+	}
+	a.Expr = expr
+	c.Assertion = a
+	return c, nil
+}
+
+// check updates the streak with interval f lasting d and returns the
+// reason to stop, or "" to go on.
+func (c *StopCondition) check(f *Finalize, d time.Duration) string {
+	v, ok := c.value(f)
+	if !ok || !c.compare(v) {
+		c.hits, c.held = 0, 0
+		return ""
+	}
+	c.hits++
+	c.held += d
+	if (c.Duration > 0 && c.held >= c.Duration) || (c.Duration == 0 && c.hits >= c.Intervals) {
+		return fmt.Sprintf("%s (actual %s)", c.Expr, c.format(v))
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStopCondition(t *testing.T) {
+	tests := []struct {
+		expr      string
+		metric    string
+		intervals int
+		duration  time.Duration
+		err       bool
+	}{
+		{expr: "p99>500ms", metric: "p99", intervals: 1},
+		{expr: "errors>5% for 3", metric: "errors", intervals: 3},
+		{expr: "success==0 for 10s", metric: "success", intervals: 1, duration: 10 * time.Second},
+		{expr: "rps<10 for 2", metric: "rps", intervals: 2},
+		{expr: "errors>5% for 0", err: true},
+		{expr: "errors>5% for -1s", err: true},
+		{expr: "errors>5% for ever", err: true},
+		{expr: "max>1s", err: true},
+		{expr: "stddev>1s for 2", err: true},
+		{expr: "bogus>1", err: true},
+	}
+	for _, tt := range tests {
+		c, err := ParseStopCondition(tt.expr)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseStopCondition(%q) = %+v, want an error", tt.expr, c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseStopCondition(%q) failed, err %v", tt.expr, err)
+			continue
+		}
+		if c.Metric != tt.metric || c.Intervals != tt.intervals || c.Duration != tt.duration || c.Expr != tt.expr {
+			t.Errorf("ParseStopCondition(%q) = %s %d %s %q, want %s %d %s", tt.expr,
+				c.Metric, c.Intervals, c.Duration, c.Expr, tt.metric, tt.intervals, tt.duration)
+		}
+	}
+}
+
+func TestStopConditionCheck(t *testing.T) {
+	bad := &Finalize{Success: 90, Err: 10}
+	good := &Finalize{Success: 100}
+	tests := []struct {
+		expr      string
+		intervals []*Finalize
+		// stop is the interval the condition stops at, -1 for none.
+		stop int
+	}{
+		{"errors>5%", []*Finalize{good, bad}, 1},
+		{"errors>5% for 2", []*Finalize{bad, good, bad, bad}, 3},
+		{"errors>5% for 3", []*Finalize{bad, bad, good, bad, bad}, -1},
+		{"errors>5% for 2s", []*Finalize{bad, bad}, 1},
+		{"errors>5% for 3s", []*Finalize{bad, good, bad, bad}, -1},
+	}
+	for _, tt := range tests {
+		c, err := ParseStopCondition(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseStopCondition(%q) failed, err %v", tt.expr, err)
+		}
+		stop := -1
+		for i, f := range tt.intervals {
+			if reason := c.check(f, time.Second); len(reason) > 0 {
+				stop = i
+				break
+			}
+		}
+		if stop != tt.stop {
+			t.Errorf("%q stopped at interval %d, want %d", tt.expr, stop, tt.stop)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Arrivals of the open model.
+const (
+	ArrivalUniform = "uniform"
+	ArrivalPoisson = "poisson"
+)
+
+func checkArrival(arrival string) error {
+	switch arrival {
+	case ArrivalUniform, ArrivalPoisson:
+		return nil
+	}
+	return fmt.Errorf("unknown arrival %q, want %s or %s", arrival, ArrivalUniform, ArrivalPoisson)
+}
+
+// schedule sends the intended start of every request of the open model on
+// ch until the run stops. The starts follow the target rate whether or not
+// the cells keep up, a request that finds no free cell waits in ch and its
+// latency is measured from its intended start.
+func (b *Worker) schedule(ch chan<- time.Time) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	next := time.Now()
+	for {
+		rate := b.targetQPS()
+		if rate <= 0 || b.isPaused() {
+			// Nothing is due at rate 0 or while paused, start afresh
+			// once requests are due again.
+			select {
+			case <-time.After(stageTick):
+				next = time.Now()
+				continue
+			case <-b.stopCh:
+				return
+			}
+		}
+		if d := time.Until(next); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-b.stopCh:
+				t.Stop()
+				return
+			}
+		}
+		select {
+		case ch <- next:
+		case <-b.stopCh:
+			return
+		}
+		gap := 1 / rate
+		if b.Arrival == ArrivalPoisson {
+			gap = r.ExpFloat64() / rate
+		}
+		next = next.Add(time.Duration(gap * float64(time.Second)))
+	}
+}
@@ -0,0 +1,364 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ErrAssertionFailed is returned by Worker.Run when an assertion fails.
+var ErrAssertionFailed = errors.New("assertions failed")
+
+type unit int
+
+const (
+	unitCount unit = iota
+	unitRate
+	unitPercent
+	unitLatency
+)
+
+// Assertion is a threshold like "p99<200ms", "errors<0.1%" or "rps>5000".
+//
+// Metrics are rps (or tps), requests, success, errors, mean (or avg), min,
+// max, stddev and pN for any percentile N. Latencies take a duration,
+// errors a count or a percentage of the requests.
+type Assertion struct {
+	Expr   string
+	Metric string
+	Op     string
+	// Value is in seconds for latencies and in percent for error rates.
+	Value float64
+
+	// percentile is set for pN metrics.
+	percentile float64
+	unit       unit
+}
+
+var assertOps = []string{"<=", ">=", "==", "!=", "<", ">"}
+
+func ParseAssertion(expr string) (*Assertion, error) {
+	s := strings.Replace(expr, " ", "", -1)
+	a := &Assertion{Expr: expr}
+	var threshold string
+	for _, op := range assertOps {
+		if i := strings.Index(s, op); i > 0 {
+			a.Metric, a.Op, threshold = strings.ToLower(s[:i]), op, s[i+len(op):]
+			break
+		}
+	}
+	if len(a.Op) == 0 || len(threshold) == 0 {
+		return nil, fmt.Errorf("assertion %q is not like metric<threshold", expr)
+	}
+	var err error
+	switch {
+	case a.Metric == "rps" || a.Metric == "tps":
+		a.unit = unitRate
+		a.Value, err = strconv.ParseFloat(threshold, 64)
+	case a.Metric == "requests" || a.Metric == "success":
+		a.unit = unitCount
+		a.Value, err = strconv.ParseFloat(threshold, 64)
+	case a.Metric == "errors":
+		if strings.HasSuffix(threshold, "%") {
+			a.unit = unitPercent
+			threshold = strings.TrimSuffix(threshold, "%")
+		}
+		a.Value, err = strconv.ParseFloat(threshold, 64)
+	case a.Metric == "mean" || a.Metric == "avg" || a.Metric == "min" ||
+		a.Metric == "max" || a.Metric == "stddev" || isPercentileMetric(a.Metric):
+		if isPercentileMetric(a.Metric) {
+			a.percentile, err = strconv.ParseFloat(strings.TrimLeft(a.Metric, "tp"), 64)
+			if err != nil || a.percentile <= 0 || a.percentile > 100 {
+				return nil, fmt.Errorf("assertion %q has an invalid percentile", expr)
+			}
+		}
+		a.unit = unitLatency
+		var d time.Duration
+		d, err = time.ParseDuration(threshold)
+		a.Value = d.Seconds()
+	default:
+		return nil, fmt.Errorf("assertion %q has an unknown metric %s", expr, a.Metric)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("assertion %q has an invalid threshold, err %v", expr, err)
+	}
+	return a, nil
+}
+
+func isPercentileMetric(m string) bool {
+	return (strings.HasPrefix(m, "p") || strings.HasPrefix(m, "tp")) && len(strings.TrimLeft(m, "tp")) > 0
+}
+
+func (a *Assertion) compare(v float64) bool {
+	switch a.Op {
+	case "<":
+		return v < a.Value
+	case "<=":
+		return v <= a.Value
+	case ">":
+		return v > a.Value
+	case ">=":
+		return v >= a.Value
+	case "==":
+		return v == a.Value
+	default:
+		return v != a.Value
+	}
+}
+
+func (a *Assertion) format(v float64) string {
+	switch a.unit {
+	case unitLatency:
+		return time.Duration(v * float64(time.Second)).String()
+	case unitPercent:
+		return strconv.FormatFloat(v, 'f', 3, 64) + "%"
+	case unitRate:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	default:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+}
+
+// perInterval reports whether intervals carry the metric.
+func (a *Assertion) perInterval() bool {
+	return a.Metric != "min" && a.Metric != "max" && a.Metric != "stddev"
+}
+
+// value picks the metric from an interval, ok is false if an interval does
+// not carry it.
+func (a *Assertion) value(f *Finalize) (v float64, ok bool) {
+	switch a.Metric {
+	case "rps", "tps":
+		return f.TPS, true
+	case "requests":
+		return float64(f.Requests), true
+	case "success":
+		return float64(f.Success), true
+	case "errors":
+		if a.unit == unitPercent {
+			return errorRate(f.Success, f.Err) * 100, true
+		}
+		return float64(f.Err), true
+	case "mean", "avg":
+		return f.AvgDelay, true
+	case "min", "max", "stddev":
+		return 0, false
+	}
+	return percentileOf(f.Percentiles, a.percentile)
+}
+
+func (a *Assertion) summaryValue(s *Summary) (float64, bool) {
+	switch a.Metric {
+	case "rps", "tps":
+		return s.RPS, true
+	case "requests":
+		return float64(s.Requests), true
+	case "success":
+		return float64(s.Success), true
+	case "errors":
+		if a.unit == unitPercent {
+			return errorRate(s.Success, s.Err) * 100, true
+		}
+		return float64(s.Err), true
+	case "mean", "avg":
+		return s.Mean, true
+	case "min":
+		return s.Min, true
+	case "max":
+		return s.Max, true
+	case "stddev":
+		return s.StdDev, true
+	}
+	return percentileOf(s.Percentiles, a.percentile)
+}
+
+func percentileOf(lats []LatencyDistribution, p float64) (float64, bool) {
+	for _, lat := range lats {
+		if lat.Percentage == p {
+			return lat.Latency, true
+		}
+	}
+	return 0, false
+}
+
+// assertionPercentiles adds the percentiles the assertions need to pctls.
+func assertionPercentiles(pctls []float64, as []*Assertion) []float64 {
+	for _, a := range as {
+		if a.percentile == 0 {
+			continue
+		}
+		found := false
+		for _, p := range pctls {
+			if p == a.percentile {
+				found = true
+				break
+			}
+		}
+		if !found {
+			pctls = insertPercentile(pctls, a.percentile)
+		}
+	}
+	return pctls
+}
+
+func insertPercentile(pctls []float64, p float64) []float64 {
+	res := make([]float64, 0, len(pctls)+1)
+	for i, q := range pctls {
+		if q > p {
+			res = append(res, p)
+			return append(res, pctls[i:]...)
+		}
+		res = append(res, q)
+	}
+	return append(res, p)
+}
+
+// AssertionResult is the outcome of an assertion over the summary or, if
+// interval is true, over every interval of the run.
+type AssertionResult struct {
+	*Assertion
+	Interval bool
+	Passed   bool
+	// Actual is the summary value, or the first failing interval value.
+	Actual float64
+	// Failures counts the failing intervals.
+	Failures int
+	// FirstFailure is the index of the first failing interval.
+	FirstFailure int
+}
+
+func (r *AssertionResult) Scope() string {
+	if r.Interval {
+		return "every interval"
+	}
+	return "summary"
+}
+
+func (r *AssertionResult) Message() string {
+	if r.Interval {
+		if r.Passed {
+			return "passed in every interval"
+		}
+		return fmt.Sprintf("failed in %d intervals, first in interval %d with %s",
+			r.Failures, r.FirstFailure, r.format(r.Actual))
+	}
+	return fmt.Sprintf("actual %s", r.format(r.Actual))
+}
+
+// checkInterval updates r with the interval f numbered index.
+func (r *AssertionResult) checkInterval(index int, f *Finalize) {
+	v, ok := r.value(f)
+	if !ok || r.compare(v) {
+		return
+	}
+	if r.Failures == 0 {
+		r.Actual = v
+		r.FirstFailure = index
+	}
+	r.Failures++
+	r.Passed = false
+}
+
+func evaluateSummary(a *Assertion, s *Summary) *AssertionResult {
+	v, ok := a.summaryValue(s)
+	return &AssertionResult{Assertion: a, Actual: v, Passed: ok && a.compare(v)}
+}
+
+// AssertionsPassed reports whether every result passed.
+func AssertionsPassed(rs []*AssertionResult) bool {
+	for _, r := range rs {
+		if !r.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// PrintAssertions writes the pass/fail table to out.
+func PrintAssertions(out io.Writer, rs []*AssertionResult) {
+	if len(rs) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\nAssertions:\n")
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	for _, r := range rs {
+		status := "PASS"
+		if !r.Passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", status, r.Expr, r.Scope(), r.Message())
+	}
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the results as a JUnit XML test suite to path.
+func WriteJUnit(path string, name string, elapsed time.Duration, rs []*AssertionResult) error {
+	suite := junitSuite{Name: name, Tests: len(rs), Time: elapsed.Seconds()}
+	for _, r := range rs {
+		c := junitCase{Name: r.Expr + " (" + r.Scope() + ")", ClassName: "smartBoom." + name}
+		if r.Passed {
+			c.SystemOut = r.Message()
+		} else {
+			suite.Failures++
+			c.Failure = &junitFailure{Message: r.Message(), Text: r.Expr + ": " + r.Message()}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	io.WriteString(f, xml.Header)
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		f.Close()
+		return err
+	}
+	io.WriteString(f, "\n")
+	return f.Close()
+}
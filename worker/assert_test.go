@@ -0,0 +1,110 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import "testing"
+
+func TestParseAssertion(t *testing.T) {
+	tests := []struct {
+		expr       string
+		metric     string
+		op         string
+		value      float64
+		percentile float64
+		unit       unit
+		err        bool
+	}{
+		{expr: "p99<200ms", metric: "p99", op: "<", value: 0.2, percentile: 99, unit: unitLatency},
+		{expr: "tp99.9 <= 1s", metric: "tp99.9", op: "<=", value: 1, percentile: 99.9, unit: unitLatency},
+		{expr: "errors<0.1%", metric: "errors", op: "<", value: 0.1, unit: unitPercent},
+		{expr: "errors==0", metric: "errors", op: "==", value: 0, unit: unitCount},
+		{expr: "rps>5000", metric: "rps", op: ">", value: 5000, unit: unitRate},
+		{expr: "TPS>=10", metric: "tps", op: ">=", value: 10, unit: unitRate},
+		{expr: "requests!=0", metric: "requests", op: "!=", value: 0, unit: unitCount},
+		{expr: "mean<50ms", metric: "mean", op: "<", value: 0.05, unit: unitLatency},
+		{expr: "stddev<10ms", metric: "stddev", op: "<", value: 0.01, unit: unitLatency},
+		{expr: "p99", err: true},
+		{expr: "p99<", err: true},
+		{expr: "<200ms", err: true},
+		{expr: "p0<1s", err: true},
+		{expr: "p101<1s", err: true},
+		{expr: "p99<200", err: true},
+		{expr: "rps>fast", err: true},
+		{expr: "bogus<1", err: true},
+	}
+	for _, tt := range tests {
+		a, err := ParseAssertion(tt.expr)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseAssertion(%q) = %+v, want an error", tt.expr, a)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAssertion(%q) failed, err %v", tt.expr, err)
+			continue
+		}
+		if a.Metric != tt.metric || a.Op != tt.op || a.Value != tt.value || a.percentile != tt.percentile || a.unit != tt.unit {
+			t.Errorf("ParseAssertion(%q) = %s %s %v p%v unit %d, want %s %s %v p%v unit %d", tt.expr,
+				a.Metric, a.Op, a.Value, a.percentile, a.unit, tt.metric, tt.op, tt.value, tt.percentile, tt.unit)
+		}
+	}
+}
+
+func TestAssertionCompare(t *testing.T) {
+	tests := []struct {
+		expr string
+		v    float64
+		want bool
+	}{
+		{"rps>100", 101, true},
+		{"rps>100", 100, false},
+		{"rps>=100", 100, true},
+		{"p99<200ms", 0.199, true},
+		{"p99<200ms", 0.2, false},
+		{"p99<=200ms", 0.2, true},
+		{"errors==0", 0, true},
+		{"errors!=0", 0, false},
+	}
+	for _, tt := range tests {
+		a, err := ParseAssertion(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseAssertion(%q) failed, err %v", tt.expr, err)
+		}
+		if got := a.compare(tt.v); got != tt.want {
+			t.Errorf("%q compare(%v) = %v, want %v", tt.expr, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestAssertionPercentiles(t *testing.T) {
+	var as []*Assertion
+	for _, expr := range []string{"p99.9<1s", "p50<1s", "rps>1"} {
+		a, err := ParseAssertion(expr)
+		if err != nil {
+			t.Fatalf("ParseAssertion(%q) failed, err %v", expr, err)
+		}
+		as = append(as, a)
+	}
+	got := assertionPercentiles([]float64{50, 90, 99}, as)
+	want := []float64{50, 90, 99, 99.9}
+	if len(got) != len(want) {
+		t.Fatalf("assertionPercentiles = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("assertionPercentiles = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"text/tabwriter"
+)
+
+// RunResult is a run loaded back from the jsonl output.
+type RunResult struct {
+	Info      *RunInfo
+	Intervals []*Finalize
+	Summary   *Summary
+}
+
+// LoadRunResult reads a file written by the jsonl output.
+func LoadRunResult(path string) (*RunResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := new(RunResult)
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; s.Scan(); line++ {
+		var typ struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(s.Bytes(), &typ); err != nil {
+			return nil, fmt.Errorf("%s:%d is not json, err %v", path, line, err)
+		}
+		switch typ.Type {
+		case "info":
+			rec := &infoRecord{RunInfo: new(RunInfo)}
+			err = json.Unmarshal(s.Bytes(), rec)
+			r.Info = rec.RunInfo
+		case "interval":
+			rec := &intervalRecord{Finalize: new(Finalize)}
+			err = json.Unmarshal(s.Bytes(), rec)
+			r.Intervals = append(r.Intervals, rec.Finalize)
+		case "summary":
+			rec := &summaryRecord{Summary: new(Summary)}
+			err = json.Unmarshal(s.Bytes(), rec)
+			r.Summary = rec.Summary
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d is invalid, err %v", path, line, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if r.Summary == nil {
+		return nil, fmt.Errorf("%s has no summary record, was the run finished", path)
+	}
+	return r, nil
+}
+
+// Tolerance bounds how much worse a result may be than its baseline.
+type Tolerance struct {
+	// RPSDrop is the allowed throughput decrease in percent.
+	RPSDrop float64
+	// ErrorIncrease is the allowed error rate increase in percentage points.
+	ErrorIncrease float64
+	// LatencyIncrease is the allowed increase of the mean and of every
+	// percentile in percent.
+	LatencyIncrease float64
+}
+
+// Delta is the comparison of one metric.
+type Delta struct {
+	Metric     string
+	Base       float64
+	Result     float64
+	Unit       string
+	Regression bool
+}
+
+// Change returns the relative change in percent, +Inf from a zero
+// baseline.
+func (d *Delta) Change() float64 {
+	if d.Base == 0 {
+		if d.Result == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (d.Result - d.Base) / d.Base * 100
+}
+
+// Compare returns the deltas of the summaries, percentiles only present
+// in one of the runs are skipped.
+func Compare(base, result *RunResult, t Tolerance) []*Delta {
+	bs, rs := base.Summary, result.Summary
+	var ds []*Delta
+
+	rps := &Delta{Metric: "requests/sec", Base: bs.RPS, Result: rs.RPS}
+	rps.Regression = rps.Change() < -t.RPSDrop
+	ds = append(ds, rps)
+
+	errs := &Delta{Metric: "error rate", Unit: "%",
+		Base: errorRate(bs.Success, bs.Err) * 100, Result: errorRate(rs.Success, rs.Err) * 100}
+	errs.Regression = errs.Result-errs.Base > t.ErrorIncrease
+	ds = append(ds, errs)
+
+	latency := func(name string, base, result float64) {
+		d := &Delta{Metric: name, Unit: "ms", Base: base * 1000, Result: result * 1000}
+		// A baseline without successes has no latency to compare with.
+		d.Regression = d.Base > 0 && d.Change() > t.LatencyIncrease
+		ds = append(ds, d)
+	}
+	latency("mean", bs.Mean, rs.Mean)
+	for _, b := range bs.Percentiles {
+		for _, r := range rs.Percentiles {
+			if b.Percentage == r.Percentage {
+				latency(PercentileName(b.Percentage), b.Latency, r.Latency)
+			}
+		}
+	}
+	// Informational only, the max of a run is too noisy to gate on.
+	ds = append(ds, &Delta{Metric: "max", Unit: "ms", Base: bs.Max * 1000, Result: rs.Max * 1000})
+	ds = append(ds, &Delta{Metric: "interval TPS stddev",
+		Base: tpsStdDev(base.Intervals), Result: tpsStdDev(result.Intervals)})
+	return ds
+}
+
+func tpsStdDev(fs []*Finalize) float64 {
+	fs = measured(fs)
+	if len(fs) == 0 {
+		return 0
+	}
+	var sum, sq float64
+	for _, f := range fs {
+		sum += f.TPS
+	}
+	mean := sum / float64(len(fs))
+	for _, f := range fs {
+		sq += (f.TPS - mean) * (f.TPS - mean)
+	}
+	return math.Sqrt(sq / float64(len(fs)))
+}
+
+// Regressed reports whether any delta is a regression.
+func Regressed(ds []*Delta) bool {
+	for _, d := range ds {
+		if d.Regression {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintDeltas writes the comparison table to out.
+func PrintDeltas(out io.Writer, ds []*Delta) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "metric\tbaseline\tresult\tchange\tstatus\n")
+	for _, d := range ds {
+		status := "ok"
+		change := fmt.Sprintf("%+.2f%%", d.Change())
+		switch {
+		case d.Regression:
+			status = "REGRESSION"
+		case math.IsInf(d.Change(), 1):
+			change, status = "n/a", "no baseline"
+		}
+		fmt.Fprintf(w, "%s\t%.3f%s\t%.3f%s\t%s\t%s\n",
+			d.Metric, d.Base, d.Unit, d.Result, d.Unit, change, status)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testRun(rps float64, success, errs int64, p99 float64) *RunResult {
+	return &RunResult{Summary: &Summary{RPS: rps, Success: success, Err: errs, Mean: p99 / 2,
+		Percentiles: []LatencyDistribution{{Percentage: 99, Latency: p99}}}}
+}
+
+func TestCompare(t *testing.T) {
+	tol := Tolerance{RPSDrop: 5, ErrorIncrease: 0.1, LatencyIncrease: 10}
+	base := testRun(1000, 1000, 0, 0.1)
+	tests := []struct {
+		name   string
+		result *RunResult
+		// regressed are the metrics over their tolerance.
+		regressed []string
+	}{
+		{"same", testRun(1000, 1000, 0, 0.1), nil},
+		{"within", testRun(951, 1000, 1, 0.109), nil},
+		{"rps drop", testRun(940, 1000, 0, 0.1), []string{"requests/sec"}},
+		{"errors", testRun(1000, 998, 2, 0.1), []string{"error rate"}},
+		{"latency", testRun(1000, 1000, 0, 0.12), []string{"mean", "TP99"}},
+		{"faster", testRun(2000, 1000, 0, 0.01), nil},
+	}
+	for _, tt := range tests {
+		ds := Compare(base, tt.result, tol)
+		var regressed []string
+		for _, d := range ds {
+			if d.Regression {
+				regressed = append(regressed, d.Metric)
+			}
+		}
+		if strings.Join(regressed, ",") != strings.Join(tt.regressed, ",") {
+			t.Errorf("%s: regressed %v, want %v", tt.name, regressed, tt.regressed)
+		}
+		if Regressed(ds) != (len(tt.regressed) > 0) {
+			t.Errorf("%s: Regressed = %v, want %v", tt.name, Regressed(ds), len(tt.regressed) > 0)
+		}
+	}
+}
+
+func TestCompareZeroBaseline(t *testing.T) {
+	// A baseline whose requests all failed has no latencies.
+	base := testRun(10, 0, 100, 0)
+	ds := Compare(base, testRun(1000, 1000, 0, 0.1), Tolerance{RPSDrop: 5, LatencyIncrease: 10})
+	for _, d := range ds {
+		if d.Regression {
+			t.Errorf("%s from a zero baseline is a regression", d.Metric)
+		}
+	}
+	var out bytes.Buffer
+	PrintDeltas(&out, ds)
+	if !strings.Contains(out.String(), "no baseline") || strings.Contains(out.String(), "Inf") {
+		t.Errorf("the zero baseline is not flagged:\n%s", out.String())
+	}
+}
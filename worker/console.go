@@ -0,0 +1,225 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// consoleSink prints one line per interval and the summary report.
+type consoleSink struct {
+	w io.Writer
+}
+
+func (c *consoleSink) Open(info *RunInfo) error {
+	return nil
+}
+
+func (c *consoleSink) WriteInterval(f *Finalize) error {
+	_, err := fmt.Fprintln(c.w, consoleLine(f))
+	return err
+}
+
+func (c *consoleSink) WriteSummary(s *Summary) error {
+	s.Print(c.w)
+	return nil
+}
+
+func (c *consoleSink) Close() error {
+	return nil
+}
+
+// consoleLine formats f for the console with latencies in milliseconds.
+func consoleLine(f *Finalize) string {
+	line := fmt.Sprintf("====>>TPS: %f, avgDelay: %fms", f.TPS, f.AvgDelay*1000)
+	if f.Warmup {
+		line = "warmup " + line
+	}
+	for _, lat := range f.Percentiles {
+		line += fmt.Sprintf(", %s: %fms", PercentileName(lat.Percentage), lat.Latency*1000)
+	}
+	if f.Stage > 0 {
+		line += fmt.Sprintf(", stage: %d, cells: %d, targetQPS: %.1f", f.Stage, f.Cells, f.TargetQPS)
+	}
+	return line
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+const (
+	sparkWidth = 60
+	barWidth   = 40
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// dashboard redraws the whole terminal every interval.
+type dashboard struct {
+	w        io.Writer
+	info     *RunInfo
+	tps      []float64
+	requests int64
+	success  int64
+	errCount int64
+	errs     map[string]int64
+	// sent and bytes count the whole run, warmup included, toward its
+	// limits.
+	sent     int64
+	bytes    int64
+}
+
+func (d *dashboard) Open(info *RunInfo) error {
+	d.info = info
+	d.errs = make(map[string]int64)
+	return nil
+}
+
+func (d *dashboard) WriteInterval(f *Finalize) error {
+	d.tps = append(d.tps, f.TPS)
+	if len(d.tps) > sparkWidth {
+		d.tps = d.tps[len(d.tps)-sparkWidth:]
+	}
+	d.sent += f.Requests
+	d.bytes += f.Size
+	if !f.Warmup {
+		d.requests += f.Requests
+		d.success += f.Success
+		d.errCount += f.Err
+		for msg, n := range f.Errors {
+			d.errs[msg] += n
+		}
+	}
+
+	var buf bytes.Buffer
+	// Move home and clear the screen.
+	buf.WriteString("\033[H\033[2J")
+	elapsed := f.TimeStamp.Sub(d.info.Start)
+	qps := "unlimited"
+	if f.TargetQPS > 0 {
+		qps = fmt.Sprintf("%.1f", f.TargetQPS)
+	}
+	fmt.Fprintf(&buf, "smartBoom  executor: %s  elapsed: %s  cells: %d  target qps: %s",
+		d.info.ExecutorName, elapsed.Truncate(time.Second), f.Cells, qps)
+	if f.Stage > 0 && f.Stage <= len(d.info.Stages) {
+		fmt.Fprintf(&buf, "  stage %d: %s", f.Stage, d.info.Stages[f.Stage-1])
+	}
+	if f.Warmup {
+		buf.WriteString("  warming up")
+	}
+	buf.WriteString("\n\n")
+	buf.WriteString(d.progress(elapsed))
+	fmt.Fprintf(&buf, "TPS %10.1f  %s\n\n", f.TPS, sparkline(d.tps))
+
+	tw := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Interval\t\n")
+	fmt.Fprintf(tw, "  success\t%d\n", f.Success)
+	fmt.Fprintf(tw, "  errors\t%d (%.2f%%)\n", f.Err, errorRate(f.Success, f.Err)*100)
+	fmt.Fprintf(tw, "  avg\t%.3f ms\n", f.AvgDelay*1000)
+	for _, lat := range f.Percentiles {
+		fmt.Fprintf(tw, "  %s\t%.3f ms\n", PercentileName(lat.Percentage), lat.Latency*1000)
+	}
+	for _, lat := range f.Uncorrected {
+		fmt.Fprintf(tw, "  uncorrected %s\t%.3f ms\n", PercentileName(lat.Percentage), lat.Latency*1000)
+	}
+	fmt.Fprintf(tw, "\nRun\t\n")
+	fmt.Fprintf(tw, "  requests\t%d\n", d.requests)
+	fmt.Fprintf(tw, "  errors\t%d (%.2f%%)\n", d.errCount, errorRate(d.success, d.errCount)*100)
+	tw.Flush()
+	if len(d.errs) > 0 {
+		buf.WriteString("\nErrors:\n")
+		for i, e := range sortErrors(d.errs) {
+			if i == 5 {
+				break
+			}
+			fmt.Fprintf(&buf, "  %8d  %s\n", e.count, e.msg)
+		}
+	}
+	_, err := d.w.Write(buf.Bytes())
+	return err
+}
+
+// progress renders a bar toward the nearest limit of the run: its planned
+// length, its requests or its bytes.
+func (d *dashboard) progress(elapsed time.Duration) string {
+	var done float64
+	var detail string
+	if d.info.Planned > 0 {
+		done = elapsed.Seconds() / d.info.Planned.Seconds()
+		detail = fmt.Sprintf("%s/%s", elapsed.Truncate(time.Second), d.info.Planned)
+	}
+	if n := d.info.MaxRequests; n > 0 && float64(d.sent)/float64(n) >= done {
+		done = float64(d.sent) / float64(n)
+		detail = fmt.Sprintf("%d/%d requests", d.sent, n)
+	}
+	if n := d.info.MaxBytes; n > 0 && float64(d.bytes)/float64(n) >= done {
+		done = float64(d.bytes) / float64(n)
+		detail = fmt.Sprintf("%d/%d bytes", d.bytes, n)
+	}
+	if len(detail) == 0 {
+		return ""
+	}
+	if done > 1 {
+		done = 1
+	}
+	n := int(done * barWidth)
+	return fmt.Sprintf("Progress [%s%s] %5.1f%%  (%s)\n",
+		strings.Repeat("#", n), strings.Repeat(".", barWidth-n), done*100, detail)
+}
+
+func (d *dashboard) WriteSummary(s *Summary) error {
+	s.Print(d.w)
+	return nil
+}
+
+func (d *dashboard) Close() error {
+	return nil
+}
+
+func sparkline(vs []float64) string {
+	var max float64
+	for _, v := range vs {
+		if v > max {
+			max = v
+		}
+	}
+	rs := make([]rune, len(vs))
+	for i, v := range vs {
+		j := 0
+		if max > 0 {
+			j = int(v / max * float64(len(sparks)-1))
+		}
+		rs[i] = sparks[j]
+	}
+	return string(rs)
+}
+
+func errorRate(success, fail int64) float64 {
+	if success+fail == 0 {
+		return 0
+	}
+	return float64(fail) / float64(success+fail)
+}
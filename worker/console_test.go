@@ -0,0 +1,82 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardProgress(t *testing.T) {
+	tests := []struct {
+		name    string
+		info    RunInfo
+		elapsed time.Duration
+		sent    int64
+		bytes   int64
+		// want is in the line, empty for no bar.
+		want string
+	}{
+		{"unbounded", RunInfo{}, time.Minute, 100, 0, ""},
+		{"planned", RunInfo{Planned: 10 * time.Second}, 5 * time.Second, 100, 0, " 50.0%  (5s/10s)"},
+		{"requests", RunInfo{MaxRequests: 400}, 5 * time.Second, 100, 0, " 25.0%  (100/400 requests)"},
+		{"bytes", RunInfo{MaxBytes: 1000}, 0, 0, 900, " 90.0%  (900/1000 bytes)"},
+		{"nearest limit", RunInfo{Planned: 10 * time.Second, MaxRequests: 400}, 2 * time.Second, 300, 0,
+			" 75.0%  (300/400 requests)"},
+		{"past the end", RunInfo{MaxRequests: 10}, 0, 20, 0, "100.0%"},
+	}
+	for _, tt := range tests {
+		d := &dashboard{info: &tt.info, sent: tt.sent, bytes: tt.bytes}
+		got := d.progress(tt.elapsed)
+		if len(tt.want) == 0 {
+			if len(got) > 0 {
+				t.Errorf("%s: progress = %q, want none", tt.name, got)
+			}
+			continue
+		}
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("%s: progress = %q, want %q in it", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWorkerPlanned(t *testing.T) {
+	stages, err := ParseStages("10s@100qps,20s@200qps")
+	if err != nil {
+		t.Fatalf("ParseStages failed, err %v", err)
+	}
+	search, err := NewSearch(SearchQPS, "100:500", 100, false, 1500*time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("NewSearch failed, err %v", err)
+	}
+	tests := []struct {
+		name string
+		b    *Worker
+		d    time.Duration
+		n    int64
+	}{
+		{"duration", &Worker{Duration: time.Minute, N: 100}, time.Minute, 100},
+		{"stages", &Worker{Duration: time.Hour, Stages: stages}, 30 * time.Second, 0},
+		// 5 steps of 1.5s held for 2 intervals of a second.
+		{"search", &Worker{Search: search, Interval: time.Second}, 10 * time.Second, 0},
+		{"iterations", &Worker{C: 10, CellIterations: 5, N: 100}, 0, 50},
+		{"iterations of stages", &Worker{C: 10, CellIterations: 5, Stages: stages}, 30 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		if d, n := tt.b.planned(); d != tt.d || n != tt.n {
+			t.Errorf("%s: planned = %s, %d, want %s, %d", tt.name, d, n, tt.d, tt.n)
+		}
+	}
+}
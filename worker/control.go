@@ -0,0 +1,149 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Control serves an HTTP API to watch and steer a running worker:
+//
+//	GET  /status            live status, see Status
+//	POST /qps?value=500     set the target rate of all cells, inf for no limit
+//	POST /cells?value=20    add or remove cells
+//	POST /pause             pause all cells
+//	POST /resume            resume all cells
+//	POST /stop              stop the run gracefully
+//
+// Stages and searches keep setting their own targets over the changes.
+type Control struct {
+	b      *Worker
+	server *http.Server
+}
+
+// Status is the answer of GET /status.
+type Status struct {
+	State     string        `json:"state"`
+	Elapsed   time.Duration `json:"elapsed"`
+	Duration  time.Duration `json:"duration,omitempty"`
+	// Progress is the done share of the run, 0 if it is not bounded.
+	Progress  float64       `json:"progress"`
+	Requests  int64         `json:"requests"`
+	Cells     int           `json:"cells"`
+	// Draining counts the removed cells still finishing a request.
+	Draining  int           `json:"draining"`
+	TargetQPS float64       `json:"target_qps"`
+	Stage     int           `json:"stage,omitempty"`
+	// Interval is the last interval, nil before the first one.
+	Interval  *Finalize     `json:"interval,omitempty"`
+}
+
+func NewControl(b *Worker) *Control {
+	return &Control{b: b}
+}
+
+// Serve starts serving the API on addr in the background.
+func (c *Control) Serve(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	c.server = &http.Server{Handler: c.handler()}
+	go c.server.Serve(l)
+	return nil
+}
+
+// handler routes the endpoints of the API.
+func (c *Control) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", c.status)
+	mux.HandleFunc("/qps", c.post(c.qps))
+	mux.HandleFunc("/cells", c.post(c.cells))
+	mux.HandleFunc("/pause", c.post(func(r *http.Request) error {
+		c.b.Pause()
+		return nil
+	}))
+	mux.HandleFunc("/resume", c.post(func(r *http.Request) error {
+		c.b.Resume()
+		return nil
+	}))
+	mux.HandleFunc("/stop", c.post(func(r *http.Request) error {
+		// Stop waits for the outputs to be written, answer first.
+		go c.b.Stop()
+		return nil
+	}))
+	return mux
+}
+
+func (c *Control) Close() error {
+	if c.server == nil {
+		return nil
+	}
+	return c.server.Close()
+}
+
+func (c *Control) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c.reply(w, c.b.Status())
+}
+
+// post wraps a handler changing the worker, it answers with the status.
+func (c *Control) post(change func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := change(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.reply(w, c.b.Status())
+	}
+}
+
+func (c *Control) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (c *Control) qps(r *http.Request) error {
+	q, err := strconv.ParseFloat(r.FormValue("value"), 64)
+	if err != nil || q < 0 || math.IsNaN(q) {
+		return fmt.Errorf("invalid qps %q", r.FormValue("value"))
+	}
+	if math.IsInf(q, 1) && c.b.OpenModel {
+		return fmt.Errorf("the open model needs a finite qps")
+	}
+	c.b.setRate(q)
+	return nil
+}
+
+func (c *Control) cells(r *http.Request) error {
+	n, err := strconv.Atoi(r.FormValue("value"))
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid cells %q", r.FormValue("value"))
+	}
+	c.b.setCells(n)
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControl(t *testing.T) {
+	b := &Worker{C: 2, QPS: 50, Duration: 5 * time.Second}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runTest(t, b)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	h := NewControl(b).handler()
+	tests := []struct {
+		method, path string
+		code         int
+		// check is run on the status of a successful reply.
+		check func(s *Status) bool
+	}{
+		{"POST", "/qps?value=20", 200, func(s *Status) bool { return s.TargetQPS == 20 }},
+		{"POST", "/cells?value=5", 200, func(s *Status) bool { return s.Cells == 5 && s.TargetQPS == 20 }},
+		{"POST", "/cells?value=1", 200, func(s *Status) bool { return s.Cells == 1 }},
+		{"POST", "/qps?value=-1", http.StatusBadRequest, nil},
+		{"POST", "/cells?value=x", http.StatusBadRequest, nil},
+		{"GET", "/qps?value=10", http.StatusMethodNotAllowed, nil},
+		{"POST", "/pause", 200, func(s *Status) bool { return s.State == "paused" }},
+		{"POST", "/resume", 200, func(s *Status) bool { return s.State == "running" }},
+		{"GET", "/status", 200, func(s *Status) bool { return s.Requests > 0 }},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s %s answered %d, want %d: %s", tt.method, tt.path, w.Code, tt.code,
+				strings.TrimSpace(w.Body.String()))
+			continue
+		}
+		if tt.check == nil {
+			continue
+		}
+		s := new(Status)
+		if err := json.Unmarshal(w.Body.Bytes(), s); err != nil {
+			t.Fatalf("%s %s answered no status, err %v", tt.method, tt.path, err)
+		}
+		if !tt.check(s) {
+			t.Errorf("%s %s answered state %s, %d cells at %vqps", tt.method, tt.path, s.State, s.Cells, s.TargetQPS)
+		}
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/stop", nil))
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("the run did not end after /stop")
+	}
+	if b.ended != EndStopped {
+		t.Errorf("the run ended for %q, want %q", b.ended, EndStopped)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+func init() {
+	RegisterSink("csv", NewCsvSink)
+}
+
+// CsvSink writes one row per interval, of type "warmup" during the warmup,
+// and a last row of type "summary" with the whole run aggregate, flushing
+// after every row.
+type CsvSink struct {
+	path   string
+	staged bool
+	f      io.WriteCloser
+	w    *csv.Writer
+}
+
+func NewCsvSink(path string) Sink {
+	return &CsvSink{path: path}
+}
+
+func (c *CsvSink) Open(info *RunInfo) error {
+	f, err := openOutput(c.path)
+	if err != nil {
+		return err
+	}
+	c.f = f
+	c.w = csv.NewWriter(f)
+	c.staged = len(info.Stages) > 0
+	return c.write(append([]string{"type"}, titles(info)...))
+}
+
+func (c *CsvSink) WriteInterval(f *Finalize) error {
+	typ := "interval"
+	if f.Warmup {
+		typ = "warmup"
+	}
+	r := c.record(typ, f.TimeStamp, f.TPS, f.AvgDelay, f.Success, f.Err, f.Percentiles, f.Uncorrected)
+	if c.staged {
+		r = append(r, strconv.Itoa(f.Stage))
+	}
+	return c.write(r)
+}
+
+func (c *CsvSink) WriteSummary(s *Summary) error {
+	r := c.record("summary", s.Start.Add(s.Duration), s.RPS, s.Mean, s.Success, s.Err, s.Percentiles, s.Uncorrected)
+	if c.staged {
+		r = append(r, "")
+	}
+	return c.write(r)
+}
+
+func (c *CsvSink) record(typ string, ts time.Time, tps, avg float64, success, fail int64, lats, uncorrected []LatencyDistribution) []string {
+	r := []string{
+		typ,
+		ts.Format(time.RFC3339Nano),
+		formatFloat(tps),
+		formatFloat(avg),
+		strconv.FormatInt(success, 10),
+		strconv.FormatInt(fail, 10),
+	}
+	for _, lat := range lats {
+		r = append(r, formatFloat(lat.Latency))
+	}
+	for _, lat := range uncorrected {
+		r = append(r, formatFloat(lat.Latency))
+	}
+	return r
+}
+
+func (c *CsvSink) write(r []string) error {
+	c.w.Write(r)
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *CsvSink) Close() error {
+	return c.f.Close()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// nopCloser keeps os.Stdout open when a sink is closed.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// openOutput creates the file at path, "-" stands for stdout.
+func openOutput(path string) (io.WriteCloser, error) {
+	if path == "-" || len(path) == 0 {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
@@ -0,0 +1,336 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterSink("html", NewHtmlSink)
+}
+
+// HtmlSink renders a single self contained html page with svg charts of
+// the intervals, the latency distribution, the configuration and the
+// summary. The page is written when the sink is closed.
+type HtmlSink struct {
+	path      string
+	info      *RunInfo
+	intervals []*Finalize
+	summary   *Summary
+}
+
+func NewHtmlSink(path string) Sink {
+	return &HtmlSink{path: path}
+}
+
+func (h *HtmlSink) Open(info *RunInfo) error {
+	h.info = info
+	if fi, err := os.Stat(h.path); len(h.path) == 0 || (err == nil && fi.IsDir()) {
+		h.path = path.Join(h.path, fmt.Sprintf("report_%s.html", info.Start.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+func (h *HtmlSink) WriteInterval(f *Finalize) error {
+	h.intervals = append(h.intervals, f)
+	return nil
+}
+
+func (h *HtmlSink) WriteSummary(s *Summary) error {
+	h.summary = s
+	return nil
+}
+
+func (h *HtmlSink) Close() error {
+	f, err := os.Create(h.path)
+	if err != nil {
+		return err
+	}
+	if err := renderHtml(f, h.info, h.intervals, h.summary); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// renderHtml writes the report page to w, summary may be nil.
+func renderHtml(w io.Writer, info *RunInfo, intervals []*Finalize, summary *Summary) error {
+	config, err := json.MarshalIndent(info.Config, "", "  ")
+	if err != nil {
+		return err
+	}
+	data := struct {
+		Info      *RunInfo
+		Config    string
+		Summary   *Summary
+		Charts    []template.HTML
+		Histogram template.HTML
+	}{
+		Info:    info,
+		Config:  string(config),
+		Summary: summary,
+		Charts:  intervalCharts(info, measured(intervals)),
+	}
+	if summary != nil {
+		data.Histogram = barChart("Latency distribution", summary.Histogram)
+	}
+	return htmlTemplate.Execute(w, data)
+}
+
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+type series struct {
+	name   string
+	values []float64
+}
+
+func intervalCharts(info *RunInfo, intervals []*Finalize) []template.HTML {
+	xs := make([]float64, len(intervals))
+	tps := series{name: "TPS", values: make([]float64, len(intervals))}
+	errRate := series{name: "error %", values: make([]float64, len(intervals))}
+	lats := make([]series, len(info.Percentiles))
+	for i, p := range info.Percentiles {
+		lats[i] = series{name: PercentileName(p), values: make([]float64, len(intervals))}
+	}
+	avg := series{name: "avg", values: make([]float64, len(intervals))}
+	for i, f := range intervals {
+		xs[i] = f.TimeStamp.Sub(info.Start).Seconds()
+		tps.values[i] = f.TPS
+		if total := f.Success + f.Err; total > 0 {
+			errRate.values[i] = float64(f.Err) * 100 / float64(total)
+		}
+		avg.values[i] = f.AvgDelay * 1000
+		for j, lat := range f.Percentiles {
+			if j < len(lats) {
+				lats[j].values[i] = lat.Latency * 1000
+			}
+		}
+	}
+	return []template.HTML{
+		lineChart("Throughput (req/s)", xs, []series{tps}),
+		lineChart("Error rate (%)", xs, []series{errRate}),
+		lineChart("Latency (ms)", xs, append([]series{avg}, lats...)),
+	}
+}
+
+const (
+	chartWidth  = 860
+	chartHeight = 260
+	chartLeft   = 60
+	chartRight  = 120
+	chartTop    = 30
+	chartBottom = 30
+)
+
+// lineChart draws series over xs seconds as an svg element.
+func lineChart(title string, xs []float64, ss []series) template.HTML {
+	var buf bytes.Buffer
+	plotW := float64(chartWidth - chartLeft - chartRight)
+	plotH := float64(chartHeight - chartTop - chartBottom)
+	maxX, maxY := 1.0, 0.0
+	for _, x := range xs {
+		maxX = math.Max(maxX, x)
+	}
+	for _, s := range ss {
+		for _, v := range s.values {
+			if !math.IsNaN(v) && !math.IsInf(v, 0) {
+				maxY = math.Max(maxY, v)
+			}
+		}
+	}
+	maxY = niceCeil(maxY)
+	chartFrame(&buf, title, maxY, func(i int) string {
+		return fmt.Sprintf("%.0fs", maxX*float64(i)/4)
+	})
+	for i, s := range ss {
+		color := chartColors[i%len(chartColors)]
+		fmt.Fprintf(&buf, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="`, color)
+		for j, v := range s.values {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			x := chartLeft + xs[j]/maxX*plotW
+			y := chartTop + plotH - v/maxY*plotH
+			fmt.Fprintf(&buf, "%.1f,%.1f ", x, y)
+		}
+		fmt.Fprintf(&buf, `"/>`)
+		ly := chartTop + 14*i
+		fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, chartWidth-chartRight+10, ly, color)
+		fmt.Fprintf(&buf, `<text x="%d" y="%d">%s</text>`, chartWidth-chartRight+25, ly+9, html.EscapeString(s.name))
+	}
+	buf.WriteString("</svg>")
+	return template.HTML(buf.String())
+}
+
+// barChart draws the latency buckets as an svg element.
+func barChart(title string, bs []Bucket) template.HTML {
+	var buf bytes.Buffer
+	plotW := float64(chartWidth - chartLeft - chartRight)
+	plotH := float64(chartHeight - chartTop - chartBottom)
+	var maxY float64
+	for _, b := range bs {
+		maxY = math.Max(maxY, float64(b.Count))
+	}
+	maxY = niceCeil(maxY)
+	chartFrame(&buf, title, maxY, func(i int) string {
+		if len(bs) == 0 {
+			return ""
+		}
+		j := i * (len(bs) - 1) / 4
+		return fmt.Sprintf("%.2fms", bs[j].From*1000)
+	})
+	for i, b := range bs {
+		w := plotW / float64(len(bs))
+		h := float64(b.Count) / maxY * plotH
+		fmt.Fprintf(&buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%.3fms - %.3fms: %d</title></rect>`,
+			chartLeft+float64(i)*w+1, chartTop+plotH-h, math.Max(w-2, 1), h, chartColors[0], b.From*1000, b.To*1000, b.Count)
+	}
+	buf.WriteString("</svg>")
+	return template.HTML(buf.String())
+}
+
+// chartFrame opens the svg element and draws the title, axes and grid,
+// xLabel returns the label of the i-th of 5 x axis ticks.
+func chartFrame(buf *bytes.Buffer, title string, maxY float64, xLabel func(i int) string) {
+	plotW := chartWidth - chartLeft - chartRight
+	plotH := chartHeight - chartTop - chartBottom
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`,
+		chartWidth, chartHeight)
+	fmt.Fprintf(buf, `<text x="%d" y="18" font-size="14" font-weight="bold">%s</text>`, chartLeft, html.EscapeString(title))
+	for i := 0; i <= 4; i++ {
+		y := chartTop + plotH - plotH*i/4
+		fmt.Fprintf(buf, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#ddd"/>`, chartLeft, y, chartLeft+plotW, y)
+		fmt.Fprintf(buf, `<text x="%d" y="%d" text-anchor="end">%s</text>`, chartLeft-5, y+4, formatTick(maxY*float64(i)/4))
+		x := chartLeft + plotW*i/4
+		fmt.Fprintf(buf, `<text x="%d" y="%d" text-anchor="middle">%s</text>`, x, chartHeight-10, xLabel(i))
+	}
+	fmt.Fprintf(buf, `<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="#999"/>`, chartLeft, chartTop, plotW, plotH)
+}
+
+// niceCeil rounds v up to 1, 2 or 5 times a power of ten.
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	exp := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*exp {
+			return m * exp
+		}
+	}
+	return 10 * exp
+}
+
+func formatTick(v float64) string {
+	if v >= 1000 || v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.3g", v)
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ms": func(seconds float64) string {
+		return fmt.Sprintf("%.3f ms", seconds*1000)
+	},
+	"pctl": PercentileName,
+	"join": strings.Join,
+	"inc": func(i int) int {
+		return i + 1
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>smartBoom report {{.Info.ExecutorName}} {{.Info.Start.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; margin: 20px; color: #333; }
+table { border-collapse: collapse; margin-bottom: 20px; }
+td, th { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+th { background: #f3f3f3; }
+pre { background: #f7f7f7; padding: 10px; }
+svg { display: block; margin-bottom: 20px; }
+</style>
+</head>
+<body>
+<h1>smartBoom report</h1>
+<h2>Configuration</h2>
+<table>
+<tr><th>executor</th><td>{{.Info.ExecutorName}}</td></tr>
+<tr><th>start</th><td>{{.Info.Start.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>requests (-n)</th><td>{{if .Info.N}}{{.Info.N}}{{else}}no limit{{end}}</td></tr>
+{{if .Info.Duration}}<tr><th>duration (-z)</th><td>{{.Info.Duration}}</td></tr>
+{{end}}{{if .Info.CellIterations}}<tr><th>iterations per worker</th><td>{{.Info.CellIterations}}</td></tr>
+{{end}}{{if .Info.MaxBytes}}<tr><th>max bytes</th><td>{{.Info.MaxBytes}}</td></tr>
+{{end}}<tr><th>concurrency (-c)</th><td>{{.Info.C}}</td></tr>
+<tr><th>qps (-q)</th><td>{{.Info.QPS}}{{if .Info.PerCellQPS}} per cell{{end}}</td></tr>
+<tr><th>interval (-i)</th><td>{{.Info.Interval}}</td></tr>
+{{range $i, $s := .Info.Stages}}<tr><th>stage {{inc $i}}</th><td>{{$s}}</td></tr>
+{{end}}{{if .Info.ThinkTime}}<tr><th>think time</th><td>{{.Info.ThinkTime}}</td></tr>
+{{end}}{{if .Info.Pacing}}<tr><th>pacing</th><td>{{.Info.Pacing}}</td></tr>
+{{end}}{{if .Info.Warmup}}<tr><th>warmup</th><td>{{.Info.Warmup}}</td></tr>
+{{end}}{{if .Info.OpenModel}}<tr><th>arrival</th><td>open model, {{.Info.Arrival}}</td></tr>
+{{end}}</table>
+<pre>{{.Config}}</pre>
+{{with .Summary}}
+<h2>Summary</h2>
+<table>
+{{if .Ended}}<tr><th>ended</th><td>{{.Ended}}</td></tr>
+{{end}}{{if .StopReason}}<tr><th>stopped</th><td>{{.StopReason}}</td></tr>
+{{end}}<tr><th>duration</th><td>{{.Duration}}</td></tr>
+<tr><th>requests</th><td>{{.Requests}}</td></tr>
+<tr><th>success</th><td>{{.Success}}</td></tr>
+<tr><th>errors</th><td>{{.Err}}</td></tr>
+<tr><th>requests/sec</th><td>{{printf "%.2f" .RPS}}</td></tr>
+<tr><th>total data</th><td>{{.Size}} bytes</td></tr>
+<tr><th>min</th><td>{{ms .Min}}</td></tr>
+<tr><th>mean</th><td>{{ms .Mean}}</td></tr>
+<tr><th>stddev</th><td>{{ms .StdDev}}</td></tr>
+<tr><th>max</th><td>{{ms .Max}}</td></tr>
+{{range .Percentiles}}<tr><th>{{pctl .Percentage}}</th><td>{{ms .Latency}}</td></tr>
+{{end}}{{range .Uncorrected}}<tr><th>uncorrected {{pctl .Percentage}}</th><td>{{ms .Latency}}</td></tr>
+{{end}}</table>
+{{with .Search}}
+<h2>Search</h2>
+<table>
+<tr><th>target {{.Dimension}}</th><th>rps</th><th>requests</th><th>errors</th>{{with .Steps}}{{range (index . 0).Percentiles}}<th>{{pctl .Percentage}}</th>{{end}}{{end}}<th>result</th></tr>
+{{range .Steps}}<tr><td>{{.Target}}</td><td>{{printf "%.2f" .RPS}}</td><td>{{.Requests}}</td><td>{{.Err}}</td>{{range .Percentiles}}<td>{{ms .Latency}}</td>{{end}}<td>{{if .Passed}}pass{{else}}fail: {{join .Failed ", "}}{{end}}</td></tr>
+{{end}}<tr><th>max {{.Dimension}}</th><td>{{if .Max}}{{.Max}}{{else}}none{{end}}</td></tr>
+</table>
+{{end}}
+{{if .StatusCodes}}
+<h2>Status codes</h2>
+<table>
+<tr><th>code</th><th>responses</th></tr>
+{{range $code, $n := .StatusCodes}}<tr><td>{{$code}}</td><td>{{$n}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}
+<h2>Charts</h2>
+{{range .Charts}}{{.}}
+{{end}}{{.Histogram}}
+</body>
+</html>
+`))
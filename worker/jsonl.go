@@ -0,0 +1,82 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+func init() {
+	RegisterSink("jsonl", NewJsonlSink)
+}
+
+// JsonlSink writes newline delimited JSON, an "info" record describing the
+// run, one "interval" record per reporter tick and a final "summary" record.
+type JsonlSink struct {
+	path string
+	f    io.WriteCloser
+	w    *bufio.Writer
+	enc  *json.Encoder
+}
+
+type infoRecord struct {
+	Type string `json:"type"`
+	*RunInfo
+}
+
+type intervalRecord struct {
+	Type string `json:"type"`
+	*Finalize
+}
+
+type summaryRecord struct {
+	Type string `json:"type"`
+	*Summary
+}
+
+func NewJsonlSink(path string) Sink {
+	return &JsonlSink{path: path}
+}
+
+func (j *JsonlSink) Open(info *RunInfo) error {
+	f, err := openOutput(j.path)
+	if err != nil {
+		return err
+	}
+	j.f = f
+	j.w = bufio.NewWriter(f)
+	j.enc = json.NewEncoder(j.w)
+	return j.write(&infoRecord{Type: "info", RunInfo: info})
+}
+
+func (j *JsonlSink) WriteInterval(f *Finalize) error {
+	return j.write(&intervalRecord{Type: "interval", Finalize: f})
+}
+
+func (j *JsonlSink) WriteSummary(s *Summary) error {
+	return j.write(&summaryRecord{Type: "summary", Summary: s})
+}
+
+func (j *JsonlSink) write(v interface{}) error {
+	if err := j.enc.Encode(v); err != nil {
+		return err
+	}
+	return j.w.Flush()
+}
+
+func (j *JsonlSink) Close() error {
+	return j.f.Close()
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Unlimited is the rate of a limiter that never blocks.
+var Unlimited = math.Inf(1)
+
+// Limiter is a token bucket shared by its callers, it refills at a rate in
+// queries per second up to burst tokens and every caller takes one. The
+// rate can be changed while they wait, a rate of 0 blocks every caller.
+type Limiter struct {
+	sync.Mutex
+	rate  float64
+	burst int
+	// tokens is negative while callers wait for reserved tokens.
+	tokens  float64
+	last    time.Time
+	changed chan struct{}
+}
+
+// NewLimiter returns a full bucket, a burst below 1 is taken as 1.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{rate: rate, burst: burst, tokens: float64(burst), last: time.Now(),
+		changed: make(chan struct{})}
+}
+
+// refill adds the tokens earned since the last call, l must be locked.
+func (l *Limiter) refill(now time.Time) {
+	if l.rate > 0 && !math.IsInf(l.rate, 1) {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if b := float64(l.burst); l.tokens > b {
+			l.tokens = b
+		}
+	}
+	l.last = now
+}
+
+func (l *Limiter) SetRate(rate float64) {
+	l.Lock()
+	defer l.Unlock()
+	if rate == l.rate {
+		return
+	}
+	l.refill(time.Now())
+	l.rate = rate
+	// The waiters reserve again at the new rate.
+	if l.tokens < 0 {
+		l.tokens = 0
+	}
+	l.wake()
+}
+
+// wake wakes up the waiters so they pick up a new rate, l must be locked.
+func (l *Limiter) wake() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *Limiter) Rate() float64 {
+	l.Lock()
+	defer l.Unlock()
+	return l.rate
+}
+
+// cancel gives back a token reserved before changed was closed, SetRate
+// already dropped the reservations since.
+func (l *Limiter) cancel(changed chan struct{}) {
+	l.Lock()
+	defer l.Unlock()
+	if l.changed != changed {
+		return
+	}
+	l.tokens++
+	if b := float64(l.burst); l.tokens > b {
+		l.tokens = b
+	}
+}
+
+// Wait blocks until the caller gets a token, it returns false if stop is
+// closed first.
+func (l *Limiter) Wait(stop <-chan struct{}) bool {
+	for {
+		l.Lock()
+		rate, changed := l.rate, l.changed
+		if math.IsInf(rate, 1) {
+			l.Unlock()
+			return true
+		}
+		var d time.Duration
+		if rate > 0 {
+			now := time.Now()
+			l.refill(now)
+			l.tokens--
+			if l.tokens < 0 {
+				d = time.Duration(-l.tokens / rate * float64(time.Second))
+			}
+		}
+		l.Unlock()
+
+		if rate > 0 {
+			if d <= 0 {
+				return true
+			}
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+				return true
+			case <-changed:
+				t.Stop()
+			case <-stop:
+				t.Stop()
+				l.cancel(changed)
+				return false
+			}
+			continue
+		}
+		select {
+		case <-changed:
+		case <-stop:
+			return false
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterWait(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		waits int
+		// min and max bound how long the waits take.
+		min, max time.Duration
+	}{
+		{"unlimited", Unlimited, 1, 1000, 0, 50 * time.Millisecond},
+		{"burst", 100, 10, 10, 0, 50 * time.Millisecond},
+		{"paced", 100, 1, 11, 90 * time.Millisecond, 500 * time.Millisecond},
+		{"burst then paced", 100, 5, 15, 90 * time.Millisecond, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		l := NewLimiter(tt.rate, tt.burst)
+		stop := make(chan struct{})
+		start := time.Now()
+		for i := 0; i < tt.waits; i++ {
+			if !l.Wait(stop) {
+				t.Fatalf("%s: Wait returned false without a stop", tt.name)
+			}
+		}
+		if d := time.Since(start); d < tt.min || d > tt.max {
+			t.Errorf("%s: %d waits took %s, want %s to %s", tt.name, tt.waits, d, tt.min, tt.max)
+		}
+	}
+}
+
+func TestLimiterStop(t *testing.T) {
+	l := NewLimiter(0, 1)
+	stop := make(chan struct{})
+	done := make(chan bool)
+	go func() {
+		done <- l.Wait(stop)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("Wait at rate 0 returned")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(stop)
+	select {
+	case ok := <-done:
+		if ok {
+			t.Errorf("Wait returned true after stop")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Wait did not return after stop")
+	}
+}
+
+func TestLimiterSetRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to float64
+		// within is how soon a waiter must get a token after SetRate.
+		within time.Duration
+	}{
+		{"resume from 0", 0, 100, 50 * time.Millisecond},
+		{"unlimit", 0, Unlimited, 50 * time.Millisecond},
+		{"speed up", 0.5, 1000, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		l := NewLimiter(tt.from, 1)
+		stop := make(chan struct{})
+		// Take the token of the full bucket.
+		if tt.from > 0 {
+			l.Wait(stop)
+		}
+		done := make(chan bool)
+		go func() {
+			done <- l.Wait(stop)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		l.SetRate(tt.to)
+		if r := l.Rate(); r != tt.to {
+			t.Errorf("%s: Rate = %v, want %v", tt.name, r, tt.to)
+		}
+		select {
+		case <-done:
+		case <-time.After(tt.within):
+			t.Errorf("%s: the waiter did not pick up the new rate", tt.name)
+		}
+		close(stop)
+	}
+}
+
+func TestLimiterStopRefund(t *testing.T) {
+	l := NewLimiter(10, 1)
+	stop := make(chan struct{})
+	l.Wait(stop)
+	// The waiter reserves the next token and gives it back on stop.
+	cancelled := make(chan struct{})
+	done := make(chan bool)
+	go func() {
+		done <- l.Wait(cancelled)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(cancelled)
+	if <-done {
+		t.Fatalf("Wait returned true after stop")
+	}
+	start := time.Now()
+	l.Wait(stop)
+	if d := time.Since(start); d > 150*time.Millisecond {
+		t.Errorf("Wait took %s after a stopped waiter, want the next token within 100ms", d)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/heidawei/smartBoom/executor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics exposes the results collected by the reporter on /metrics.
+type Metrics struct {
+	name     string
+	requests *prometheus.CounterVec
+	errors   prometheus.Counter
+	bytes    prometheus.Counter
+	latency  *prometheus.HistogramVec
+	cells    prometheus.Gauge
+	qps      prometheus.Gauge
+
+	// observers and counters cache the series of every status code.
+	observers map[int]prometheus.Observer
+	counters  map[int]prometheus.Counter
+
+	registry *prometheus.Registry
+	server   *http.Server
+}
+
+func NewMetrics(executorName string) *Metrics {
+	labels := prometheus.Labels{"executor": executorName}
+	m := &Metrics{
+		name: executorName,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smartboom_requests_total",
+			Help: "Number of requests done, by executor and status code.",
+		}, []string{"executor", "code"}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "smartboom_errors_total",
+			Help:        "Number of failed requests.",
+			ConstLabels: labels,
+		}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "smartboom_bytes_total",
+			Help:        "Number of content bytes received.",
+			ConstLabels: labels,
+		}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "smartboom_request_duration_seconds",
+			Help:    "Latency of successful requests, by executor and status code.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 20),
+		}, []string{"executor", "code"}),
+		cells: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "smartboom_active_cells",
+			Help:        "Number of cells sending requests.",
+			ConstLabels: labels,
+		}),
+		qps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "smartboom_target_qps",
+			Help:        "Target rate in queries per second, 0 means no limit.",
+			ConstLabels: labels,
+		}),
+		observers: make(map[int]prometheus.Observer),
+		counters:  make(map[int]prometheus.Counter),
+		registry:  prometheus.NewRegistry(),
+	}
+	m.registry.MustRegister(m.requests, m.errors, m.bytes, m.latency, m.cells, m.qps,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+// Serve starts serving /metrics on addr in the background.
+func (m *Metrics) Serve(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	m.server = &http.Server{Handler: mux}
+	go m.server.Serve(l)
+	return nil
+}
+
+func (m *Metrics) Close() error {
+	if m.server == nil {
+		return nil
+	}
+	return m.server.Close()
+}
+
+// observe must be called from the reporter goroutine only.
+func (m *Metrics) observe(res *executor.Result) {
+	counter, found := m.counters[res.StatusCode]
+	if !found {
+		counter = m.requests.WithLabelValues(m.name, strconv.Itoa(res.StatusCode))
+		m.counters[res.StatusCode] = counter
+	}
+	if res.Count == 0 {
+		counter.Inc()
+	} else {
+		counter.Add(float64(res.Count))
+	}
+	if res.Err != nil {
+		m.errors.Inc()
+		return
+	}
+	observer, found := m.observers[res.StatusCode]
+	if !found {
+		observer = m.latency.WithLabelValues(m.name, strconv.Itoa(res.StatusCode))
+		m.observers[res.StatusCode] = observer
+	}
+	observer.Observe(res.Duration.Seconds())
+	if res.ContentLength > 0 {
+		m.bytes.Add(float64(res.ContentLength))
+	}
+}
+
+func (m *Metrics) setCells(n int) {
+	m.cells.Set(float64(n))
+}
+
+func (m *Metrics) setQPS(qps float64) {
+	m.qps.Set(qps)
+}
@@ -0,0 +1,214 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/heidawei/smartBoom/executor"
+)
+
+// RawLog writes every result as a line of gzip compressed JSON. The first
+// line is the RunInfo of the run, the others are LogRecords and the last
+// one is a LogEnd under "end" if the run finished.
+type RawLog struct {
+	f     *os.File
+	gz    *gzip.Writer
+	w     *bufio.Writer
+	start time.Time
+	buf   []byte
+}
+
+// LogRecord is one result, Start is the offset from RunInfo.Start.
+type LogRecord struct {
+	Start    time.Duration `json:"start"`
+	Cell     int           `json:"cell"`
+	Duration time.Duration `json:"latency"`
+	Delay    time.Duration `json:"delay,omitempty"`
+	Code     int           `json:"code"`
+	Size     int64         `json:"size"`
+	Count    int           `json:"count"`
+	Err      string        `json:"err,omitempty"`
+}
+
+// LogEnd is the trailer of a log, Elapsed is the length of the run which
+// the results alone do not tell.
+type LogEnd struct {
+	Elapsed    time.Duration `json:"elapsed"`
+	Ended      string        `json:"ended,omitempty"`
+	StopReason string        `json:"stop_reason,omitempty"`
+}
+
+// logLine is a line after the header, either a record or the trailer.
+type logLine struct {
+	LogRecord
+	End *LogEnd `json:"end,omitempty"`
+}
+
+func CreateRawLog(path string, info *RunInfo) (*RawLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &RawLog{f: f, gz: gzip.NewWriter(f), start: info.Start}
+	l.w = bufio.NewWriterSize(l.gz, 64*1024)
+	if err := json.NewEncoder(l.w).Encode(info); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Write appends res done by cell, it must not be called concurrently.
+func (l *RawLog) Write(cell int, res *executor.Result) error {
+	b := l.buf[:0]
+	b = append(b, `{"start":`...)
+	b = strconv.AppendInt(b, int64(res.Start.Sub(l.start)), 10)
+	b = append(b, `,"cell":`...)
+	b = strconv.AppendInt(b, int64(cell), 10)
+	b = append(b, `,"latency":`...)
+	b = strconv.AppendInt(b, int64(res.Duration), 10)
+	if res.Delay > 0 {
+		b = append(b, `,"delay":`...)
+		b = strconv.AppendInt(b, int64(res.Delay), 10)
+	}
+	b = append(b, `,"code":`...)
+	b = strconv.AppendInt(b, int64(res.StatusCode), 10)
+	b = append(b, `,"size":`...)
+	b = strconv.AppendInt(b, res.ContentLength, 10)
+	b = append(b, `,"count":`...)
+	b = strconv.AppendInt(b, int64(res.Count), 10)
+	if res.Err != nil {
+		msg, _ := json.Marshal(res.Err.Error())
+		b = append(b, `,"err":`...)
+		b = append(b, msg...)
+	}
+	b = append(b, "}\n"...)
+	l.buf = b
+	_, err := l.w.Write(b)
+	return err
+}
+
+// WriteEnd appends the trailer, it is the last line of the log.
+func (l *RawLog) WriteEnd(end *LogEnd) error {
+	return json.NewEncoder(l.w).Encode(struct {
+		End *LogEnd `json:"end"`
+	}{end})
+}
+
+func (l *RawLog) Close() error {
+	err := l.w.Flush()
+	if e := l.gz.Close(); e != nil && err == nil {
+		err = e
+	}
+	if e := l.f.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// RawLogReader reads a log written by RawLog.
+type RawLogReader struct {
+	f    *os.File
+	gz   *gzip.Reader
+	s    *bufio.Scanner
+	Info *RunInfo
+	// End is the trailer once Next returned io.EOF, nil if the log has
+	// none like the log of a run that crashed.
+	End  *LogEnd
+	// Truncated is set once Next returned io.EOF if the log was cut off,
+	// the results before the cut are read.
+	Truncated bool
+}
+
+func OpenRawLog(path string) (*RawLogReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a result log, err %v", path, err)
+	}
+	r := &RawLogReader{f: f, gz: gz, s: bufio.NewScanner(gz)}
+	r.s.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !r.s.Scan() {
+		r.Close()
+		return nil, fmt.Errorf("%s is not a result log, missing header", path)
+	}
+	r.Info = new(RunInfo)
+	if err := json.Unmarshal(r.s.Bytes(), r.Info); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("%s is not a result log, err %v", path, err)
+	}
+	return r, nil
+}
+
+// Next returns the next record or io.EOF after the last one.
+func (r *RawLogReader) Next() (*LogRecord, error) {
+	if !r.s.Scan() {
+		err := r.s.Err()
+		if err == io.ErrUnexpectedEOF {
+			r.Truncated = true
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	line := new(logLine)
+	if err := json.Unmarshal(r.s.Bytes(), line); err != nil {
+		// The last line of a cut off log is partial.
+		if r.s.Err() == io.ErrUnexpectedEOF {
+			r.Truncated = true
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if line.End != nil {
+		r.End = line.End
+		return nil, io.EOF
+	}
+	return &line.LogRecord, nil
+}
+
+// Result converts rec back into the result the executor returned.
+func (rec *LogRecord) Result() *executor.Result {
+	res := &executor.Result{
+		StatusCode:    rec.Code,
+		Duration:      rec.Duration,
+		Delay:         rec.Delay,
+		ContentLength: rec.Size,
+		Count:         rec.Count,
+	}
+	if len(rec.Err) > 0 {
+		res.Err = errors.New(rec.Err)
+	}
+	return res
+}
+
+func (r *RawLogReader) Close() error {
+	r.gz.Close()
+	return r.f.Close()
+}
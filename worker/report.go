@@ -0,0 +1,203 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Report regenerates the outputs of a run from the log written with
+// Worker.LogPath, slicing it with another interval or percentile set.
+type Report struct {
+	// Path is the result log to read.
+	Path string
+
+	// Interval is the width of the rebuilt intervals.
+	Interval time.Duration
+
+	SigFigs     int
+	MaxLatency  time.Duration
+	Percentiles []float64
+	Outputs     []string
+
+	// Writer is where the console report is written. If nil, it is written to stdout.
+	Writer io.Writer
+}
+
+func (r *Report) Run() error {
+	log, err := OpenRawLog(r.Path)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+	if r.Interval <= 0 {
+		r.Interval = log.Info.Interval
+	}
+	if r.SigFigs <= 0 {
+		r.SigFigs = DefaultSigFigs
+	}
+	if r.MaxLatency <= 0 {
+		r.MaxLatency = DefaultMaxLatency
+	}
+	if len(r.Percentiles) == 0 {
+		r.Percentiles = DefaultPercentiles
+	}
+	w := r.Writer
+	if w == nil {
+		w = os.Stdout
+	}
+	ss := sinks{&consoleSink{w: w}}
+	for _, spec := range r.Outputs {
+		s, err := ParseOutput(spec)
+		if err != nil {
+			return err
+		}
+		ss = append(ss, s)
+	}
+	info := *log.Info
+	info.Interval = r.Interval
+	info.Percentiles = r.Percentiles
+	if err := ss.Open(&info); err != nil {
+		return err
+	}
+
+	// Results are logged when collected, so a start may lag the results
+	// around it by up to the longest latency. Keep that many intervals open.
+	slack := int64(r.MaxLatency/r.Interval) + 1
+	newInterim := func() *interim {
+		it := NewInterim(r.MaxLatency, r.SigFigs)
+		if info.OpenModel {
+			it.trackUncorrected()
+		}
+		return it
+	}
+	open := make(map[int64]*interim)
+	// warm holds the results started during the warmup, they are written
+	// as warmup rows and left out of the summary.
+	warm := make(map[int64]*interim)
+	var free []*interim
+	total := newInterim()
+	var next, last int64
+	var end time.Duration
+	flush := func(k int64) error {
+		width := r.Interval
+		if rest := end - time.Duration(k)*r.Interval; rest > 0 && rest < width {
+			width = rest
+		}
+		if it, found := warm[k]; found {
+			f := it.finalize(width, r.Percentiles)
+			f.TimeStamp = info.Start.Add(time.Duration(k)*r.Interval + width)
+			f.Warmup = true
+			delete(warm, k)
+			it.reset()
+			free = append(free, it)
+			if err := ss.WriteInterval(f); err != nil {
+				return err
+			}
+		}
+		it, found := open[k]
+		if !found && time.Duration(k+1)*r.Interval <= info.Warmup {
+			return nil
+		}
+		if !found {
+			if len(free) == 0 {
+				free = append(free, newInterim())
+			}
+			it = free[len(free)-1]
+		}
+		f := it.finalize(width, r.Percentiles)
+		f.TimeStamp = info.Start.Add(time.Duration(k)*r.Interval + width)
+		total.merge(it)
+		if found {
+			delete(open, k)
+			it.reset()
+			free = append(free, it)
+		}
+		return ss.WriteInterval(f)
+	}
+	for {
+		rec, err := log.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			ss.Close()
+			return err
+		}
+		k := int64(rec.Start / r.Interval)
+		if k < next {
+			k = next
+		}
+		bucket := open
+		if rec.Start < info.Warmup {
+			bucket = warm
+		}
+		it, found := bucket[k]
+		if !found {
+			if len(free) > 0 {
+				it, free = free[len(free)-1], free[:len(free)-1]
+			} else {
+				it = newInterim()
+			}
+			bucket[k] = it
+		}
+		it.add(rec.Result())
+		if e := rec.Start + rec.Duration; e > end {
+			end = e
+		}
+		if k > last {
+			last = k
+		}
+		for ; next < k-slack; next++ {
+			if err := flush(next); err != nil {
+				ss.Close()
+				return err
+			}
+		}
+	}
+	// The results end before the run if it idled at the end, the trailer
+	// has the real length.
+	if log.End != nil && log.End.Elapsed > end {
+		end = log.End.Elapsed
+		if k := int64((end - 1) / r.Interval); k > last {
+			last = k
+		}
+	}
+	for ; next <= last; next++ {
+		if err := flush(next); err != nil {
+			ss.Close()
+			return err
+		}
+	}
+	elapsed := end - info.Warmup
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	if log.Truncated {
+		fmt.Fprintf(w, "%s is cut off, the report covers the results before the cut\n", r.Path)
+	}
+	s := total.summarize(info.Start.Add(info.Warmup), elapsed, r.Percentiles)
+	s.Warmup = info.Warmup
+	if log.End != nil {
+		s.Ended, s.StopReason = log.End.Ended, log.End.StopReason
+	}
+	err = ss.WriteSummary(s)
+	if e := ss.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// readSummary returns the summary record of a jsonl output.
+func readSummary(t *testing.T, p string) *Summary {
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		var rec struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			t.Fatalf("%s has a bad line, err %v", p, err)
+		}
+		if rec.Type == "summary" {
+			sum := new(Summary)
+			if err := json.Unmarshal(s.Bytes(), sum); err != nil {
+				t.Fatalf("%s has a bad summary, err %v", p, err)
+			}
+			return sum
+		}
+	}
+	t.Fatalf("%s has no summary", p)
+	return nil
+}
+
+func TestReportRoundtrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := path.Join(dir, "run.log.gz")
+	b := &Worker{ExecutorName: "test-sleep", N: 300, C: 4, Interval: 20 * time.Millisecond,
+		Writer: ioutil.Discard, LogPath: logPath, Outputs: []string{"jsonl:" + path.Join(dir, "run.jsonl")}}
+	if err := b.Run(); err != nil {
+		t.Fatalf("Run failed, err %v", err)
+	}
+	run := readSummary(t, path.Join(dir, "run.jsonl"))
+
+	r := &Report{Path: logPath, Writer: ioutil.Discard, Outputs: []string{"jsonl:" + path.Join(dir, "report.jsonl")}}
+	if err := r.Run(); err != nil {
+		t.Fatalf("Report.Run failed, err %v", err)
+	}
+	rebuilt := readSummary(t, path.Join(dir, "report.jsonl"))
+	if rebuilt.Requests != run.Requests || rebuilt.Success != run.Success || rebuilt.Err != run.Err ||
+		rebuilt.Size != run.Size {
+		t.Errorf("rebuilt requests %d, success %d, err %d, size %d, want %d, %d, %d, %d", rebuilt.Requests,
+			rebuilt.Success, rebuilt.Err, rebuilt.Size, run.Requests, run.Success, run.Err, run.Size)
+	}
+	if rebuilt.Duration != run.Duration {
+		t.Errorf("rebuilt duration %s, want %s", rebuilt.Duration, run.Duration)
+	}
+	if rebuilt.Ended != EndRequests || rebuilt.Ended != run.Ended {
+		t.Errorf("rebuilt end %q, run end %q, want %q", rebuilt.Ended, run.Ended, EndRequests)
+	}
+	if len(rebuilt.Percentiles) != len(run.Percentiles) {
+		t.Fatalf("%d rebuilt percentiles, want %d", len(rebuilt.Percentiles), len(run.Percentiles))
+	}
+	for i, lat := range run.Percentiles {
+		if rebuilt.Percentiles[i] != lat {
+			t.Errorf("rebuilt %s = %v, want %v", PercentileName(lat.Percentage), rebuilt.Percentiles[i].Latency,
+				lat.Latency)
+		}
+	}
+
+	// A log cut off like the one of a crashed run still reports the
+	// results before the cut, without a trailer.
+	data, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cutPath := path.Join(dir, "cut.log.gz")
+	if err := ioutil.WriteFile(cutPath, data[:len(data)*2/3], 0644); err != nil {
+		t.Fatal(err)
+	}
+	r = &Report{Path: cutPath, Writer: ioutil.Discard, Outputs: []string{"jsonl:" + path.Join(dir, "cut.jsonl")}}
+	if err := r.Run(); err != nil {
+		t.Fatalf("Report.Run of a cut log failed, err %v", err)
+	}
+	cut := readSummary(t, path.Join(dir, "cut.jsonl"))
+	if cut.Requests <= 0 || cut.Requests >= run.Requests {
+		t.Errorf("the cut log has %d requests, want some of %d", cut.Requests, run.Requests)
+	}
+	if len(cut.Ended) > 0 {
+		t.Errorf("the cut log ended for %q, want no trailer", cut.Ended)
+	}
+}
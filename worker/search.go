@@ -0,0 +1,244 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Search dimensions.
+const (
+	SearchQPS   = "qps"
+	SearchCells = "cells"
+)
+
+// minRPSRatio is the share of the target rate a step must reach to pass, a
+// server that cannot keep up slows the cells down instead of failing SLOs.
+const minRPSRatio = 0.95
+
+// Search looks for the highest rate, or concurrency, that meets the SLO.
+// Every step holds its target for at least Hold, rounded up to whole
+// intervals, and passes if every SLO assertion holds over the step. It
+// either steps up from Low by Step until a step fails or High is passed,
+// or, if Binary is set, bisects [Low, High] until the bounds are closer
+// than Step.
+type Search struct {
+	Dimension string
+	Low       float64
+	High      float64
+	Step      float64
+	Binary    bool
+	Hold      time.Duration
+	SLO       []*Assertion
+
+	target float64
+	lo, hi float64
+	held   time.Duration
+	acc    *interim
+	result *SearchResult
+}
+
+// SearchResult is the outcome of a search, Max is 0 if no step passed.
+type SearchResult struct {
+	Dimension string        `json:"dimension"`
+	Max       float64       `json:"max"`
+	Steps     []*SearchStep `json:"steps"`
+}
+
+// SearchStep is the aggregate of one step, latencies are in seconds.
+type SearchStep struct {
+	Target      float64               `json:"target"`
+	Duration    time.Duration         `json:"duration"`
+	RPS         float64               `json:"rps"`
+	Requests    int64                 `json:"requests"`
+	Err         int64                 `json:"err"`
+	Percentiles []LatencyDistribution `json:"percentiles"`
+	Passed      bool                  `json:"passed"`
+	// Failed lists why the step failed.
+	Failed []string `json:"failed,omitempty"`
+}
+
+// NewSearch returns a search over dimension in rng like "100:5000".
+func NewSearch(dimension string, rng string, step float64, binary bool, hold time.Duration, slo []*Assertion) (*Search, error) {
+	if dimension != SearchQPS && dimension != SearchCells {
+		return nil, fmt.Errorf("unknown search dimension %q, want %s or %s", dimension, SearchQPS, SearchCells)
+	}
+	bounds := strings.Split(rng, ":")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("search range %q is not like low:high", rng)
+	}
+	low, err := strconv.ParseFloat(strings.TrimSpace(bounds[0]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("search range %q is invalid, err %v", rng, err)
+	}
+	high, err := strconv.ParseFloat(strings.TrimSpace(bounds[1]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("search range %q is invalid, err %v", rng, err)
+	}
+	if low <= 0 || high <= low {
+		return nil, fmt.Errorf("search range %q must have 0 < low < high", rng)
+	}
+	if step <= 0 {
+		return nil, fmt.Errorf("search step must be positive")
+	}
+	if hold <= 0 {
+		return nil, fmt.Errorf("search hold must be positive")
+	}
+	if dimension == SearchCells {
+		low, high, step = math.Ceil(low), math.Floor(high), math.Max(1, math.Round(step))
+	}
+	for _, a := range slo {
+		if !a.perInterval() {
+			return nil, fmt.Errorf("slo %q uses %s which steps do not carry", a.Expr, a.Metric)
+		}
+	}
+	return &Search{Dimension: dimension, Low: low, High: high, Step: step, Binary: binary, Hold: hold, SLO: slo}, nil
+}
+
+// start returns the first target.
+func (s *Search) start(acc *interim) float64 {
+	s.acc = acc
+	s.lo, s.hi = s.Low, s.High
+	s.target = s.Low
+	s.result = &SearchResult{Dimension: s.Dimension}
+	return s.target
+}
+
+// planned returns the longest the search lasts, every step holding Hold
+// rounded up to intervals of interval.
+func (s *Search) planned(interval time.Duration) time.Duration {
+	hold := s.Hold
+	if interval > 0 {
+		hold = (hold + interval - 1) / interval * interval
+	}
+	steps := 1 + math.Ceil((s.High-s.Low)/s.Step)
+	if s.Binary {
+		steps = 1 + math.Ceil(math.Log2((s.High-s.Low)/s.Step))
+	}
+	return time.Duration(steps) * hold
+}
+
+// add folds interval r lasting d into the step, it returns the next target
+// once the step is over, and done once the search is.
+func (s *Search) add(r *interim, d time.Duration, pctls []float64) (next float64, over, done bool) {
+	s.acc.merge(r)
+	s.held += d
+	if s.held < s.Hold {
+		return s.target, false, false
+	}
+	step := s.evaluate(pctls)
+	s.acc.reset()
+	s.held = 0
+	s.result.Steps = append(s.result.Steps, step)
+	if step.Passed {
+		s.result.Max = s.target
+	}
+	switch {
+	case !s.Binary:
+		if !step.Passed || s.target >= s.High {
+			return 0, true, true
+		}
+		s.target = math.Min(s.target+s.Step, s.High)
+	case len(s.result.Steps) == 1 && !step.Passed:
+		// Nothing in the range holds.
+		return 0, true, true
+	default:
+		if step.Passed {
+			s.lo = s.target
+		} else {
+			s.hi = s.target
+		}
+		if s.hi-s.lo <= s.Step {
+			return 0, true, true
+		}
+		s.target = (s.lo + s.hi) / 2
+		if s.Dimension == SearchCells {
+			s.target = math.Floor(s.target)
+		}
+		if s.target <= s.lo {
+			return 0, true, true
+		}
+	}
+	return s.target, true, false
+}
+
+func (s *Search) evaluate(pctls []float64) *SearchStep {
+	sum := s.acc.summarize(time.Time{}, s.held, pctls)
+	step := &SearchStep{
+		Target:      s.target,
+		Duration:    s.held,
+		RPS:         sum.RPS,
+		Requests:    sum.Requests,
+		Err:         sum.Err,
+		Percentiles: sum.Percentiles,
+		Passed:      true,
+	}
+	for _, a := range s.SLO {
+		if r := evaluateSummary(a, sum); !r.Passed {
+			step.Failed = append(step.Failed, fmt.Sprintf("%s (actual %s)", a.Expr, a.format(r.Actual)))
+		}
+	}
+	if s.Dimension == SearchQPS && sum.RPS < s.target*minRPSRatio {
+		step.Failed = append(step.Failed, fmt.Sprintf("rps %.2f below target", sum.RPS))
+	}
+	step.Passed = len(step.Failed) == 0
+	return step
+}
+
+// applySearch moves the worker to a search target.
+func (b *Worker) applySearch(target float64) {
+	if b.Search.Dimension == SearchCells {
+		b.setCells(int(target))
+	} else {
+		b.setRate(target)
+	}
+}
+
+// Print writes the steps and the result of the search to out.
+func (r *SearchResult) Print(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "\nSearch:\n")
+	fmt.Fprintf(w, "  target %s\trps\terrors", r.Dimension)
+	// The highest percentile stands for the latency of a step.
+	var top LatencyDistribution
+	if len(r.Steps) > 0 && len(r.Steps[0].Percentiles) > 0 {
+		top = r.Steps[0].Percentiles[len(r.Steps[0].Percentiles)-1]
+		fmt.Fprintf(w, "\t%s", PercentileName(top.Percentage))
+	}
+	fmt.Fprintf(w, "\tresult\n")
+	for _, st := range r.Steps {
+		fmt.Fprintf(w, "  %s\t%.2f\t%d", formatFloat(st.Target), st.RPS, st.Err)
+		if top.Percentage > 0 {
+			lat, _ := percentileOf(st.Percentiles, top.Percentage)
+			fmt.Fprintf(w, "\t%.4f ms", lat*1000)
+		}
+		if st.Passed {
+			fmt.Fprintf(w, "\tPASS\n")
+		} else {
+			fmt.Fprintf(w, "\tFAIL %s\n", strings.Join(st.Failed, ", "))
+		}
+	}
+	if r.Max > 0 {
+		fmt.Fprintf(w, "  Max %s:\t%s\n", r.Dimension, formatFloat(r.Max))
+	} else {
+		fmt.Fprintf(w, "  Max %s:\tnone, the first step failed\n", r.Dimension)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunInfo describes the run recorded by a sink.
+type RunInfo struct {
+	ExecutorName string                 `json:"executor"`
+	N            int                    `json:"n"`
+	C            int                    `json:"c"`
+	QPS          float64                `json:"qps"`
+	PerCellQPS   bool                   `json:"per_cell_qps,omitempty"`
+	Interval     time.Duration          `json:"interval"`
+	Duration     time.Duration          `json:"duration"`
+	// CellIterations and MaxBytes are the other limits of the run, 0 for
+	// none.
+	CellIterations int                  `json:"cell_iterations,omitempty"`
+	MaxBytes     int64                  `json:"max_bytes,omitempty"`
+	// Planned is the longest the run lasts and MaxRequests the most
+	// requests it makes, warmup included, 0 for no bound.
+	Planned      time.Duration          `json:"planned,omitempty"`
+	MaxRequests  int64                  `json:"max_requests,omitempty"`
+	Percentiles  []float64              `json:"percentiles"`
+	Config       map[string]interface{} `json:"config"`
+	Start        time.Time              `json:"start"`
+	Stages       []string               `json:"stages,omitempty"`
+	// OpenModel is set if latencies are measured from the intended starts.
+	OpenModel    bool                   `json:"open_model,omitempty"`
+	Arrival      string                 `json:"arrival,omitempty"`
+	Warmup       time.Duration          `json:"warmup,omitempty"`
+	ThinkTime    string                 `json:"think_time,omitempty"`
+	Pacing       time.Duration          `json:"pacing,omitempty"`
+}
+
+// Sink receives the statistics of a run. WriteInterval is called once per
+// reporter tick and WriteSummary once after the run, all from one goroutine.
+type Sink interface {
+	Open(info *RunInfo) error
+	WriteInterval(f *Finalize) error
+	WriteSummary(s *Summary) error
+	Close() error
+}
+
+// CreateSink returns a sink writing to path, the part after the colon of
+// an output spec like "xlsx:path".
+type CreateSink func(path string) Sink
+
+type SinkRegister struct {
+	sync.RWMutex
+	cache map[string]CreateSink
+}
+
+var sinkRegister = &SinkRegister{cache: make(map[string]CreateSink)}
+
+func RegisterSink(name string, new CreateSink) bool {
+	sinkRegister.Lock()
+	defer sinkRegister.Unlock()
+	if _, found := sinkRegister.cache[name]; found {
+		return false
+	}
+	sinkRegister.cache[name] = new
+	return true
+}
+
+func GetSink(name string) (CreateSink, bool) {
+	sinkRegister.RLock()
+	defer sinkRegister.RUnlock()
+	if s, found := sinkRegister.cache[name]; found {
+		return s, true
+	}
+	return nil, false
+}
+
+// ParseOutput creates the sink described by spec, "name:path" or "name".
+func ParseOutput(spec string) (Sink, error) {
+	name, path := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, path = spec[:i], spec[i+1:]
+	}
+	s, found := GetSink(name)
+	if !found {
+		return nil, fmt.Errorf("unknown output %q", name)
+	}
+	return s(path), nil
+}
+
+// sinks fans every call out to all of its members.
+type sinks []Sink
+
+func (ss sinks) Open(info *RunInfo) error {
+	for i, s := range ss {
+		if err := s.Open(info); err != nil {
+			// Release the sinks opened so far.
+			ss[:i].Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss sinks) WriteInterval(f *Finalize) error {
+	var first error
+	for _, s := range ss {
+		if err := s.WriteInterval(f); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (ss sinks) WriteSummary(s *Summary) error {
+	var first error
+	for _, sink := range ss {
+		if err := sink.WriteSummary(s); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (ss sinks) Close() error {
+	var first error
+	for _, s := range ss {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
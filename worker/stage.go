@@ -0,0 +1,180 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stageTick is how often the rate and concurrency follow a ramp.
+const stageTick = 100 * time.Millisecond
+
+// Stage is one step of a load plan, like "30s@100qps", "2m@50c",
+// "5m@2000qps/50c" or "1m ramp to 5000qps". A ramp moves linearly from the
+// values at the end of the previous stage, targets a stage leaves out are
+// inherited from it.
+type Stage struct {
+	Expr     string
+	Duration time.Duration
+	Ramp     bool
+	// QPS is the rate of all cells together, negative if not set. A stage
+	// at 0qps sends nothing.
+	QPS float64
+	// Cells is the number of active cells, 0 if not set.
+	Cells int
+}
+
+// ParseStages parses stages separated by commas or new lines, lines
+// starting with # are ignored.
+func ParseStages(s string) ([]*Stage, error) {
+	var stages []*Stage
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, expr := range strings.Split(line, ",") {
+			expr = strings.TrimSpace(expr)
+			if len(expr) == 0 {
+				continue
+			}
+			st, err := parseStage(expr)
+			if err != nil {
+				return nil, err
+			}
+			stages = append(stages, st)
+		}
+	}
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("empty stage plan")
+	}
+	return stages, nil
+}
+
+func parseStage(expr string) (*Stage, error) {
+	st := &Stage{Expr: expr, QPS: -1}
+	var dur, targets string
+	if i := strings.Index(expr, " ramp to "); i > 0 {
+		st.Ramp = true
+		dur, targets = expr[:i], expr[i+len(" ramp to "):]
+	} else if i := strings.Index(expr, "@"); i > 0 {
+		dur, targets = expr[:i], expr[i+1:]
+	} else {
+		return nil, fmt.Errorf("stage %q is not like 30s@100qps or 1m ramp to 500qps", expr)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(dur))
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("stage %q has an invalid duration", expr)
+	}
+	st.Duration = d
+	for _, t := range strings.Split(targets, "/") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch {
+		case strings.HasSuffix(t, "c"):
+			n, err := strconv.Atoi(strings.TrimSuffix(t, "c"))
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("stage %q has an invalid concurrency %q", expr, t)
+			}
+			st.Cells = n
+		default:
+			q, err := strconv.ParseFloat(strings.TrimSuffix(t, "qps"), 64)
+			if err != nil || q < 0 {
+				return nil, fmt.Errorf("stage %q has an invalid rate %q", expr, t)
+			}
+			st.QPS = q
+		}
+	}
+	return st, nil
+}
+
+// StagesDuration returns the length of the whole plan.
+func StagesDuration(stages []*Stage) time.Duration {
+	var d time.Duration
+	for _, st := range stages {
+		d += st.Duration
+	}
+	return d
+}
+
+func stageExprs(stages []*Stage) []string {
+	var exprs []string
+	for _, st := range stages {
+		exprs = append(exprs, st.Expr)
+	}
+	return exprs
+}
+
+// stagePoint is the target of a plan at some time, qps is negative if no
+// stage set a rate so far.
+type stagePoint struct {
+	stage int
+	qps   float64
+	cells int
+}
+
+// stageAt returns the target at elapsed, ok is false once the plan is over.
+// cells is the concurrency before any stage sets one.
+func stageAt(stages []*Stage, elapsed time.Duration, cells int) (p stagePoint, ok bool) {
+	qps := -1.0
+	var from time.Duration
+	for i, st := range stages {
+		nextQPS, nextCells := qps, cells
+		if st.QPS >= 0 {
+			nextQPS = st.QPS
+		}
+		if st.Cells > 0 {
+			nextCells = st.Cells
+		}
+		if elapsed < from+st.Duration {
+			p = stagePoint{stage: i + 1, qps: nextQPS, cells: nextCells}
+			if st.Ramp {
+				frac := float64(elapsed-from) / float64(st.Duration)
+				if qps >= 0 && st.QPS >= 0 {
+					p.qps = qps + (st.QPS-qps)*frac
+				} else if st.QPS >= 0 {
+					p.qps = st.QPS * frac
+				}
+				if st.Cells > 0 {
+					p.cells = cells + int(float64(st.Cells-cells)*frac+0.5)
+				}
+			}
+			return p, true
+		}
+		qps, cells = nextQPS, nextCells
+		from += st.Duration
+	}
+	return stagePoint{stage: len(stages), qps: qps, cells: cells}, false
+}
+
+// runStages follows the plan until it is over or the worker stops.
+func (b *Worker) runStages() {
+	t := time.NewTicker(stageTick)
+	defer t.Stop()
+	for {
+		p, ok := stageAt(b.Stages, time.Since(b.start), b.C)
+		if !ok {
+			b.stopFor(EndStages)
+			return
+		}
+		b.apply(p)
+		select {
+		case <-b.stopCh:
+			return
+		case <-t.C:
+		}
+	}
+}
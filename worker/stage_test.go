@@ -0,0 +1,108 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStage(t *testing.T) {
+	tests := []struct {
+		expr     string
+		duration time.Duration
+		ramp     bool
+		qps      float64
+		cells    int
+		err      bool
+	}{
+		{expr: "30s@100qps", duration: 30 * time.Second, qps: 100},
+		{expr: "30s@100", duration: 30 * time.Second, qps: 100},
+		{expr: "1m@50c", duration: time.Minute, qps: -1, cells: 50},
+		{expr: "1m@200qps/20c", duration: time.Minute, qps: 200, cells: 20},
+		{expr: "2m ramp to 500qps", duration: 2 * time.Minute, ramp: true, qps: 500},
+		{expr: "10s@0qps", duration: 10 * time.Second, qps: 0},
+		{expr: "30s", err: true},
+		{expr: "0s@100qps", err: true},
+		{expr: "soon@100qps", err: true},
+		{expr: "30s@-1qps", err: true},
+		{expr: "30s@0c", err: true},
+		{expr: "30s@manyc", err: true},
+	}
+	for _, tt := range tests {
+		st, err := parseStage(tt.expr)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseStage(%q) = %+v, want an error", tt.expr, st)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseStage(%q) failed, err %v", tt.expr, err)
+			continue
+		}
+		if st.Duration != tt.duration || st.Ramp != tt.ramp || st.QPS != tt.qps || st.Cells != tt.cells {
+			t.Errorf("parseStage(%q) = %s ramp %v %vqps %dc, want %s ramp %v %vqps %dc", tt.expr,
+				st.Duration, st.Ramp, st.QPS, st.Cells, tt.duration, tt.ramp, tt.qps, tt.cells)
+		}
+	}
+}
+
+func TestParseStages(t *testing.T) {
+	stages, err := ParseStages("# warm up\n10s@10qps, 20s ramp to 100qps\n\n30s@100qps/10c\n")
+	if err != nil {
+		t.Fatalf("ParseStages failed, err %v", err)
+	}
+	if len(stages) != 3 {
+		t.Fatalf("ParseStages returned %d stages, want 3", len(stages))
+	}
+	if d := StagesDuration(stages); d != time.Minute {
+		t.Errorf("StagesDuration = %s, want 1m", d)
+	}
+	if _, err := ParseStages("# nothing\n"); err == nil {
+		t.Errorf("ParseStages of an empty plan did not fail")
+	}
+}
+
+func TestStageAt(t *testing.T) {
+	stages, err := ParseStages("10s@100qps, 10s ramp to 300qps/20c, 5s@0qps")
+	if err != nil {
+		t.Fatalf("ParseStages failed, err %v", err)
+	}
+	ramp, err := ParseStages("10s ramp to 100qps")
+	if err != nil {
+		t.Fatalf("ParseStages failed, err %v", err)
+	}
+	tests := []struct {
+		stages  []*Stage
+		elapsed time.Duration
+		want    stagePoint
+		ok      bool
+	}{
+		{stages, 0, stagePoint{stage: 1, qps: 100, cells: 5}, true},
+		{stages, 9 * time.Second, stagePoint{stage: 1, qps: 100, cells: 5}, true},
+		{stages, 10 * time.Second, stagePoint{stage: 2, qps: 100, cells: 5}, true},
+		{stages, 15 * time.Second, stagePoint{stage: 2, qps: 200, cells: 13}, true},
+		{stages, 20 * time.Second, stagePoint{stage: 3, qps: 0, cells: 20}, true},
+		{stages, 25 * time.Second, stagePoint{stage: 3, qps: 0, cells: 20}, false},
+		{ramp, 0, stagePoint{stage: 1, qps: 0, cells: 5}, true},
+		{ramp, 5 * time.Second, stagePoint{stage: 1, qps: 50, cells: 5}, true},
+	}
+	for _, tt := range tests {
+		p, ok := stageAt(tt.stages, tt.elapsed, 5)
+		if p != tt.want || ok != tt.ok {
+			t.Errorf("stageAt(%s) = %+v %v, want %+v %v", tt.elapsed, p, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -14,8 +14,15 @@
 package worker
 
 import (
-	"time"
+	"fmt"
+	"math"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/heidawei/smartBoom/executor"
 )
 
 var startTime = time.Now()
@@ -30,62 +37,158 @@ func min(a, b int) int {
 	return b
 }
 
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 type interim struct {
 	avgTotal float64
-	lats     []float64
+	hist     *hdrhistogram.Histogram
+	// uncorrected leaves out the delays of the open model, nil if the
+	// run does not follow it.
+	uncorrected *hdrhistogram.Histogram
 	numRes   int64
 	successCount int64
 	sizeTotal int64
 	errCount int64
+	codes    map[int]int64
+	errs     map[string]int64
+}
+
+// maxErrorKinds bounds the distinct error messages kept per interim, the
+// rest are counted under otherErrors.
+const (
+	maxErrorKinds = 16
+	otherErrors   = "other errors"
+)
+
+// NewInterim returns an interim whose latency histogram tracks values
+// from 1us up to maxLatency with sigfigs significant decimal digits.
+func NewInterim(maxLatency time.Duration, sigfigs int) *interim {
+	highest := int64(maxLatency / time.Microsecond)
+	if highest < 2 {
+		highest = 2
+	}
+	return &interim{hist: hdrhistogram.New(1, highest, sigfigs), codes: make(map[int]int64),
+		errs: make(map[string]int64)}
+}
+
+// trackUncorrected makes i keep the latencies without the open model
+// delays next to the corrected ones.
+func (i *interim) trackUncorrected() *interim {
+	i.uncorrected = hdrhistogram.New(i.hist.LowestTrackableValue(), i.hist.HighestTrackableValue(),
+		int(i.hist.SignificantFigures()))
+	return i
+}
+
+func (i *interim) add(res *executor.Result) {
+	if res.Count == 0 {
+		i.numRes++
+	} else {
+		i.numRes += int64(res.Count)
+	}
+	if res.StatusCode > 0 {
+		i.codes[res.StatusCode]++
+	}
+	if res.Err != nil {
+		i.errCount++
+		i.addErr(res.Err.Error(), 1)
+		return
+	}
+	i.successCount++
+	i.avgTotal += res.Duration.Seconds()
+	record(i.hist, res.Duration)
+	if i.uncorrected != nil {
+		record(i.uncorrected, res.Duration-res.Delay)
+	}
+	if res.ContentLength > 0 {
+		i.sizeTotal += res.ContentLength
+	}
+}
+
+func (i *interim) addErr(msg string, n int64) {
+	if _, found := i.errs[msg]; !found && len(i.errs) >= maxErrorKinds {
+		msg = otherErrors
+	}
+	i.errs[msg] += n
+}
+
+// record clamps d into the trackable range so that outliers are still
+// counted instead of being dropped by the histogram.
+func record(h *hdrhistogram.Histogram, d time.Duration) {
+	v := int64(d / time.Microsecond)
+	if v < h.LowestTrackableValue() {
+		v = h.LowestTrackableValue()
+	} else if v > h.HighestTrackableValue() {
+		v = h.HighestTrackableValue()
+	}
+	h.RecordValue(v)
 }
 
-func NewInterim() *interim {
-	return &interim{lats: make([]float64, 0, 100000)}
+// merge folds o into i, the histograms must share the same settings.
+func (i *interim) merge(o *interim) {
+	i.avgTotal += o.avgTotal
+	i.hist.Merge(o.hist)
+	if i.uncorrected != nil && o.uncorrected != nil {
+		i.uncorrected.Merge(o.uncorrected)
+	}
+	i.numRes += o.numRes
+	i.successCount += o.successCount
+	i.sizeTotal += o.sizeTotal
+	i.errCount += o.errCount
+	for code, n := range o.codes {
+		i.codes[code] += n
+	}
+	for msg, n := range o.errs {
+		i.addErr(msg, n)
+	}
 }
 
 func (i *interim) reset() {
-	if i.lats != nil {
-		i.lats = i.lats[:0]
+	i.hist.Reset()
+	if i.uncorrected != nil {
+		i.uncorrected.Reset()
 	}
 	i.numRes = 0
 	i.avgTotal = 0.0
 	i.successCount = 0
 	i.errCount = 0
 	i.sizeTotal = 0
+	for code := range i.codes {
+		delete(i.codes, code)
+	}
+	for msg := range i.errs {
+		delete(i.errs, msg)
+	}
 }
 
-func (i *interim) finalize(total time.Duration) *Finalize {
+func (i *interim) finalize(total time.Duration, pctls []float64) *Finalize {
 	tps := float64(i.numRes) / total.Seconds()
-	l := len(i.lats)
-	average := i.avgTotal / float64(l)
-	// sort
-	sort.Float64s(i.lats)
-	ls := latencies(i.lats)
+	var average float64
+	if i.successCount > 0 {
+		average = i.avgTotal / float64(i.successCount)
+	}
 
 	f := &Finalize{
 		TimeStamp: time.Now(),
 		TPS: tps,
 		AvgDelay: average,
+		Requests: i.numRes,
 		Success: i.successCount,
 		Err: i.errCount,
 		Size: i.sizeTotal,
+		Percentiles: latencies(i.hist, pctls),
+	}
+	if i.uncorrected != nil {
+		f.Uncorrected = latencies(i.uncorrected, pctls)
 	}
-	for _, lat := range ls {
-		switch lat.Percentage {
-		case 10:
-			f.TP10 = lat.Latency
-		case 25:
-			f.TP25 = lat.Latency
-		case 50:
-			f.TP50 = lat.Latency
-		case 75:
-			f.TP75 = lat.Latency
-		case 90:
-			f.TP90 = lat.Latency
-		case 95:
-			f.TP95 = lat.Latency
-		case 99:
-			f.TP99 = lat.Latency
+	if len(i.errs) > 0 {
+		f.Errors = make(map[string]int64, len(i.errs))
+		for msg, n := range i.errs {
+			f.Errors[msg] = n
 		}
 	}
 	return f
@@ -95,41 +198,87 @@ type Finalize struct {
 	TimeStamp time.Time     `json:"timestamp"`
 	TPS       float64       `json:"tps"`
 	AvgDelay  float64       `json:"avg_delay"`
+	Requests  int64         `json:"requests"`
 	Success   int64         `json:"success"`
 	Err       int64         `json:"err"`
 	Size      int64         `json:"size"`
-	TP10      float64       `json:"tp10"`
-	TP25      float64       `json:"tp25"`
-	TP50      float64       `json:"tp50"`
-	TP75      float64       `json:"tp75"`
-	TP90      float64       `json:"tp90"`
-	TP95      float64       `json:"tp95"`
-	TP99      float64       `json:"tp99"`
-}
-
-func latencies(lats []float64) []LatencyDistribution {
-	pctls := []int{10, 25, 50, 75, 90, 95, 99}
-	data := make([]float64, len(pctls))
-	j := 0
-	for i := 0; i < len(lats) && j < len(pctls); i++ {
-		current := i * 100 / len(lats)
-		if current >= pctls[j] {
-			data[j] = lats[i]
-			j++
+	Percentiles []LatencyDistribution `json:"percentiles"`
+	// Uncorrected are the percentiles measured from the actual starts,
+	// only set in the open model.
+	Uncorrected []LatencyDistribution `json:"uncorrected,omitempty"`
+	Errors    map[string]int64 `json:"errors,omitempty"`
+	// Cells and TargetQPS are the concurrency and the rate aimed for at
+	// the end of the interval.
+	Cells     int           `json:"cells"`
+	TargetQPS float64       `json:"target_qps"`
+	// Stage is the 1-based index of the active stage, 0 without stages.
+	Stage     int           `json:"stage,omitempty"`
+	// Warmup marks the results of requests started during the warmup.
+	Warmup    bool          `json:"warmup,omitempty"`
+}
+
+// measured returns the intervals after the warmup, the ones the summary
+// covers.
+func measured(fs []*Finalize) []*Finalize {
+	res := make([]*Finalize, 0, len(fs))
+	for _, f := range fs {
+		if !f.Warmup {
+			res = append(res, f)
 		}
 	}
-	// pending
-	for i := j; i < len(pctls) && j > 0; i++ {
-		data[i] = data[i-1]
-	}
+	return res
+}
+
+// latencies returns the latency in seconds at each percentile.
+func latencies(h *hdrhistogram.Histogram, pctls []float64) []LatencyDistribution {
 	res := make([]LatencyDistribution, len(pctls))
+	// The histogram rounds the count at a low percentile of a sparse
+	// interval down to 0 and answers 0, the lowest one to ask for is the
+	// one of the fastest request.
+	var lowest float64
+	if n := h.TotalCount(); n > 0 {
+		lowest = 100 / float64(n)
+	}
 	for i := 0; i < len(pctls); i++ {
-		res[i] = LatencyDistribution{Percentage: pctls[i], Latency: data[i]}
+		var lat float64
+		if h.TotalCount() > 0 {
+			lat = usToSeconds(h.ValueAtQuantile(math.Max(pctls[i], lowest)))
+		}
+		res[i] = LatencyDistribution{Percentage: pctls[i], Latency: lat}
 	}
 	return res
 }
 
 type LatencyDistribution struct {
-	Percentage int
-	Latency    float64
+	Percentage float64 `json:"percentage"`
+	Latency    float64 `json:"latency"`
+}
+
+// ParsePercentiles parses a comma separated list like "50,90,99,99.9,100".
+func ParsePercentiles(s string) ([]float64, error) {
+	var pctls []float64
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			continue
+		}
+		p, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid percentile %q", field)
+		}
+		if p <= 0 || p > 100 {
+			return nil, fmt.Errorf("percentile %v out of range (0, 100]", p)
+		}
+		pctls = append(pctls, p)
+	}
+	if len(pctls) == 0 {
+		return nil, fmt.Errorf("empty percentile list")
+	}
+	sort.Float64s(pctls)
+	return pctls, nil
+}
+
+// PercentileName returns the column name of percentile p, e.g. TP99.9.
+func PercentileName(p float64) string {
+	return "TP" + strconv.FormatFloat(p, 'f', -1, 64)
 }
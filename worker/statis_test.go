@@ -0,0 +1,159 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/heidawei/smartBoom/executor"
+)
+
+// near reports whether got is within the 3 significant figures of the
+// histogram of want.
+func near(got, want float64) bool {
+	return math.Abs(got-want) <= want*1e-3
+}
+
+func newTestInterim(ms ...float64) *interim {
+	i := NewInterim(time.Minute, 3)
+	for _, v := range ms {
+		i.add(&executor.Result{StatusCode: 200, Count: 1, Duration: time.Duration(v * float64(time.Millisecond))})
+	}
+	return i
+}
+
+func TestLatencies(t *testing.T) {
+	hundred := make([]float64, 100)
+	for i := range hundred {
+		hundred[i] = float64(i + 1)
+	}
+	tests := []struct {
+		name  string
+		ms    []float64
+		pctls []float64
+		// want are the latencies in milliseconds.
+		want []float64
+	}{
+		{"empty", nil, []float64{50, 100}, []float64{0, 0}},
+		{"one request", []float64{5}, []float64{1, 50, 99.9, 100}, []float64{5, 5, 5, 5}},
+		{"hundred", hundred, []float64{1, 50, 99, 100}, []float64{1, 50, 99, 100}},
+		// p10 of 4 requests is below the fastest one, it is clamped to
+		// p25 instead of reading 0.
+		{"sparse", []float64{1, 2, 3, 4}, []float64{10, 50, 100}, []float64{1, 2, 4}},
+	}
+	for _, tt := range tests {
+		i := newTestInterim(tt.ms...)
+		got := latencies(i.hist, tt.pctls)
+		for j, lat := range got {
+			if lat.Percentage != tt.pctls[j] || !near(lat.Latency*1000, tt.want[j]) {
+				t.Errorf("%s: %s = %vms, want %vms", tt.name, PercentileName(tt.pctls[j]), lat.Latency*1000, tt.want[j])
+			}
+		}
+	}
+}
+
+func TestRecordClamps(t *testing.T) {
+	i := NewInterim(time.Second, 3)
+	record(i.hist, 0)
+	record(i.hist, time.Hour)
+	if n := i.hist.TotalCount(); n != 2 {
+		t.Fatalf("%d values recorded, want 2", n)
+	}
+	if v := i.hist.Min(); v != 1 {
+		t.Errorf("min = %dus, want 1us", v)
+	}
+	if v := i.hist.Max(); !near(float64(v), 1e6) {
+		t.Errorf("max = %dus, want the 1s limit", v)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := newTestInterim(1, 2)
+	a.add(&executor.Result{Err: errors.New("refused"), Count: 1})
+	b := newTestInterim(3)
+	b.add(&executor.Result{StatusCode: 500, Count: 3, Duration: 4 * time.Millisecond, ContentLength: 10})
+	b.add(&executor.Result{Err: errors.New("refused"), Count: 1})
+	a.merge(b)
+	if a.numRes != 8 || a.successCount != 4 || a.errCount != 2 || a.sizeTotal != 10 {
+		t.Errorf("merged requests %d, success %d, err %d, size %d, want 8, 4, 2, 10",
+			a.numRes, a.successCount, a.errCount, a.sizeTotal)
+	}
+	if a.codes[200] != 3 || a.codes[500] != 1 {
+		t.Errorf("merged codes %v, want 3 of 200 and 1 of 500", a.codes)
+	}
+	if a.errs["refused"] != 2 {
+		t.Errorf("merged errors %v, want 2 refused", a.errs)
+	}
+	if n := a.hist.TotalCount(); n != 4 {
+		t.Errorf("merged histogram counts %d, want 4", n)
+	}
+
+	// Error kinds past the bound are counted together.
+	c := NewInterim(time.Minute, 3)
+	for k := 0; k < maxErrorKinds+5; k++ {
+		o := NewInterim(time.Minute, 3)
+		o.add(&executor.Result{Err: fmt.Errorf("error %d", k), Count: 1})
+		c.merge(o)
+	}
+	if len(c.errs) != maxErrorKinds+1 || c.errs[otherErrors] != 5 {
+		t.Errorf("%d error kinds, %d other errors, want %d and 5", len(c.errs), c.errs[otherErrors], maxErrorKinds+1)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name    string
+		ms      []float64
+		elapsed time.Duration
+		rps     float64
+		mean    float64
+		buckets int
+	}{
+		{"empty", nil, time.Second, 0, 0, 0},
+		{"one request", []float64{5}, time.Second, 1, 5, 1},
+		{"spread", []float64{1, 2, 5, 10, 100, 1000}, 2 * time.Second, 3, 186.333, histogramBuckets},
+		{"no elapsed time", []float64{1}, 0, 0, 1, 1},
+	}
+	for _, tt := range tests {
+		i := newTestInterim(tt.ms...)
+		s := i.summarize(time.Time{}, tt.elapsed, []float64{50, 100})
+		if s.Requests != int64(len(tt.ms)) || !near(s.RPS, tt.rps) || !near(s.Mean*1000, tt.mean) {
+			t.Errorf("%s: requests %d, rps %v, mean %vms, want %d, %v, %vms", tt.name, s.Requests, s.RPS,
+				s.Mean*1000, len(tt.ms), tt.rps, tt.mean)
+		}
+		if len(s.Histogram) != tt.buckets {
+			t.Errorf("%s: %d buckets, want %d", tt.name, len(s.Histogram), tt.buckets)
+			continue
+		}
+		var count int64
+		for j, b := range s.Histogram {
+			count += b.Count
+			if b.To < b.From || (j > 0 && !near(b.From, s.Histogram[j-1].To)) {
+				t.Errorf("%s: bucket %d is [%v, %v] after one ending at %v", tt.name, j, b.From, b.To,
+					s.Histogram[j-1].To)
+			}
+		}
+		if count != int64(len(tt.ms)) {
+			t.Errorf("%s: buckets count %d latencies, want %d", tt.name, count, len(tt.ms))
+		}
+		if len(tt.ms) > 0 && (!near(s.Min*1000, tt.ms[0]) || !near(s.Max*1000, tt.ms[len(tt.ms)-1])) {
+			t.Errorf("%s: min %vms, max %vms, want %vms and %vms", tt.name, s.Min*1000, s.Max*1000,
+				tt.ms[0], tt.ms[len(tt.ms)-1])
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// Summary is the aggregate of a whole run, latencies are in seconds.
+type Summary struct {
+	Start       time.Time             `json:"start"`
+	Duration    time.Duration         `json:"duration"`
+	Requests    int64                 `json:"requests"`
+	Success     int64                 `json:"success"`
+	Err         int64                 `json:"err"`
+	RPS         float64               `json:"rps"`
+	Min         float64               `json:"min"`
+	Mean        float64               `json:"mean"`
+	StdDev      float64               `json:"stddev"`
+	Max         float64               `json:"max"`
+	Percentiles []LatencyDistribution `json:"percentiles"`
+	// Uncorrected are the percentiles measured from the actual starts,
+	// only set in the open model.
+	Uncorrected []LatencyDistribution `json:"uncorrected,omitempty"`
+	Size        int64                 `json:"size"`
+	StatusCodes map[int]int64         `json:"status_codes"`
+	Errors      map[string]int64      `json:"errors,omitempty"`
+	Histogram   []Bucket              `json:"histogram"`
+	// Ended is why the run ended, see EndRequests and the other reasons,
+	// empty if the summary was rebuilt from a log without a trailer.
+	Ended       string                `json:"ended,omitempty"`
+	// StopReason is the stop condition that aborted the run, empty if
+	// none did.
+	StopReason  string                `json:"stop_reason,omitempty"`
+	// Warmup is the left out start of the run, Start and Duration follow
+	// it.
+	Warmup      time.Duration         `json:"warmup,omitempty"`
+	// Search is the outcome of -find-max, nil without a search.
+	Search      *SearchResult         `json:"search,omitempty"`
+}
+
+// Bucket counts the latencies in [From, To] seconds.
+type Bucket struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count int64   `json:"count"`
+}
+
+const histogramBuckets = 40
+
+func (i *interim) summarize(start time.Time, elapsed time.Duration, pctls []float64) *Summary {
+	s := &Summary{
+		Start:       start,
+		Duration:    elapsed,
+		Requests:    i.numRes,
+		Success:     i.successCount,
+		Err:         i.errCount,
+		Percentiles: latencies(i.hist, pctls),
+		Size:        i.sizeTotal,
+		StatusCodes: make(map[int]int64, len(i.codes)),
+	}
+	if elapsed > 0 {
+		s.RPS = float64(i.numRes) / elapsed.Seconds()
+	}
+	if i.successCount > 0 {
+		s.Min = usToSeconds(i.hist.Min())
+		s.Mean = i.avgTotal / float64(i.successCount)
+		s.StdDev = i.hist.StdDev() / 1e6
+		s.Max = usToSeconds(i.hist.Max())
+	}
+	for code, n := range i.codes {
+		s.StatusCodes[code] = n
+	}
+	if len(i.errs) > 0 {
+		s.Errors = make(map[string]int64, len(i.errs))
+		for msg, n := range i.errs {
+			s.Errors[msg] = n
+		}
+	}
+	if i.uncorrected != nil {
+		s.Uncorrected = latencies(i.uncorrected, pctls)
+	}
+	s.Histogram = distribution(i.hist, histogramBuckets)
+	return s
+}
+
+// distribution folds the histogram into n logarithmically sized buckets
+// between its min and max value.
+func distribution(h *hdrhistogram.Histogram, n int) []Bucket {
+	if h.TotalCount() == 0 {
+		return nil
+	}
+	lo, hi := float64(h.Min()), float64(h.Max())
+	// Max is the top of the bucket of the slowest value, one value still
+	// spans a bucket.
+	if h.ValuesAreEquivalent(h.Min(), h.Max()) {
+		return []Bucket{{From: usToSeconds(h.Min()), To: usToSeconds(h.Max()), Count: h.TotalCount()}}
+	}
+	bs := make([]Bucket, n)
+	ratio := math.Pow(hi/lo, 1/float64(n))
+	edge := lo
+	for i := range bs {
+		bs[i].From = edge / 1e6
+		edge *= ratio
+		bs[i].To = edge / 1e6
+	}
+	for _, bar := range h.Distribution() {
+		if bar.Count == 0 {
+			continue
+		}
+		i := int(math.Log(float64(bar.From)/lo) / math.Log(ratio))
+		if i < 0 {
+			i = 0
+		} else if i >= n {
+			i = n - 1
+		}
+		bs[i].Count += bar.Count
+	}
+	return bs
+}
+
+// Codes returns the status codes in ascending order.
+func (s *Summary) Codes() []int {
+	codes := make([]int, 0, len(s.StatusCodes))
+	for code := range s.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+// Print writes a human readable report of s to w.
+func (s *Summary) Print(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "\nSummary:\n")
+	if len(s.Ended) > 0 {
+		fmt.Fprintf(w, "  Ended:\t%s\n", s.Ended)
+	}
+	if len(s.StopReason) > 0 {
+		fmt.Fprintf(w, "  Stopped:\t%s\n", s.StopReason)
+	}
+	if s.Warmup > 0 {
+		fmt.Fprintf(w, "  Warmup:\t%s left out\n", s.Warmup)
+	}
+	fmt.Fprintf(w, "  Total:\t%4.4f secs\n", s.Duration.Seconds())
+	fmt.Fprintf(w, "  Requests:\t%d\n", s.Requests)
+	fmt.Fprintf(w, "  Success:\t%d\n", s.Success)
+	fmt.Fprintf(w, "  Errors:\t%d\n", s.Err)
+	fmt.Fprintf(w, "  Requests/sec:\t%4.4f\n", s.RPS)
+	fmt.Fprintf(w, "  Total data:\t%d bytes\n", s.Size)
+
+	fmt.Fprintf(w, "\nLatency:\n")
+	fmt.Fprintf(w, "  Min:\t%4.4f ms\n", s.Min*1000)
+	fmt.Fprintf(w, "  Mean:\t%4.4f ms\n", s.Mean*1000)
+	fmt.Fprintf(w, "  StdDev:\t%4.4f ms\n", s.StdDev*1000)
+	fmt.Fprintf(w, "  Max:\t%4.4f ms\n", s.Max*1000)
+
+	fmt.Fprintf(w, "\nLatency distribution:\n")
+	for _, lat := range s.Percentiles {
+		fmt.Fprintf(w, "  %s:\t%4.4f ms\n", PercentileName(lat.Percentage), lat.Latency*1000)
+	}
+	if len(s.Uncorrected) > 0 {
+		fmt.Fprintf(w, "\nUncorrected latency distribution:\n")
+		for _, lat := range s.Uncorrected {
+			fmt.Fprintf(w, "  %s:\t%4.4f ms\n", PercentileName(lat.Percentage), lat.Latency*1000)
+		}
+	}
+
+	if len(s.StatusCodes) > 0 {
+		fmt.Fprintf(w, "\nStatus code distribution:\n")
+		for _, code := range s.Codes() {
+			fmt.Fprintf(w, "  [%d]\t%d responses\n", code, s.StatusCodes[code])
+		}
+	}
+
+	if len(s.Errors) > 0 {
+		fmt.Fprintf(w, "\nError distribution:\n")
+		for _, e := range sortErrors(s.Errors) {
+			fmt.Fprintf(w, "  [%d]\t%s\n", e.count, e.msg)
+		}
+	}
+	if s.Search != nil {
+		w.Flush()
+		s.Search.Print(out)
+	}
+}
+
+type errorCount struct {
+	msg   string
+	count int64
+}
+
+// sortErrors returns the errors ordered by descending count.
+func sortErrors(errs map[string]int64) []errorCount {
+	es := make([]errorCount, 0, len(errs))
+	for msg, n := range errs {
+		es = append(es, errorCount{msg: msg, count: n})
+	}
+	sort.Slice(es, func(i, j int) bool {
+		if es[i].count != es[j].count {
+			return es[i].count > es[j].count
+		}
+		return es[i].msg < es[j].msg
+	})
+	return es
+}
+
+func usToSeconds(us int64) float64 {
+	return (time.Duration(us) * time.Microsecond).Seconds()
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Think time distributions.
+const (
+	ThinkConstant    = "constant"
+	ThinkUniform     = "uniform"
+	ThinkNormal      = "normal"
+	ThinkExponential = "exp"
+)
+
+// ThinkTime is the pause of a cell between two requests, like
+// "constant:100ms", "uniform:50ms-200ms", "normal:100ms,20ms" for a mean
+// and a standard deviation, or "exp:100ms" for a mean.
+type ThinkTime struct {
+	Expr string
+	Kind string
+	A    time.Duration
+	B    time.Duration
+}
+
+func ParseThinkTime(expr string) (*ThinkTime, error) {
+	i := strings.Index(expr, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("think time %q is not like kind:durations", expr)
+	}
+	t := &ThinkTime{Expr: expr, Kind: strings.TrimSpace(expr[:i])}
+	args := strings.TrimSpace(expr[i+1:])
+	var err error
+	switch t.Kind {
+	case ThinkConstant, ThinkExponential:
+		t.A, err = time.ParseDuration(args)
+	case ThinkUniform:
+		err = t.parsePair(args, "-")
+		if err == nil && t.B < t.A {
+			err = fmt.Errorf("the upper bound is below the lower one")
+		}
+	case ThinkNormal:
+		err = t.parsePair(args, ",")
+	default:
+		return nil, fmt.Errorf("think time %q has an unknown kind %s, want %s, %s, %s or %s", expr, t.Kind,
+			ThinkConstant, ThinkUniform, ThinkNormal, ThinkExponential)
+	}
+	if err == nil && (t.A < 0 || t.B < 0) {
+		err = fmt.Errorf("durations cannot be negative")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("think time %q is invalid, err %v", expr, err)
+	}
+	return t, nil
+}
+
+func (t *ThinkTime) parsePair(args, sep string) error {
+	parts := strings.Split(args, sep)
+	if len(parts) != 2 {
+		return fmt.Errorf("want two durations separated by %q", sep)
+	}
+	var err error
+	if t.A, err = time.ParseDuration(strings.TrimSpace(parts[0])); err != nil {
+		return err
+	}
+	t.B, err = time.ParseDuration(strings.TrimSpace(parts[1]))
+	return err
+}
+
+// next draws a think time, never below 0.
+func (t *ThinkTime) next(r *rand.Rand) time.Duration {
+	var d time.Duration
+	switch t.Kind {
+	case ThinkConstant:
+		d = t.A
+	case ThinkUniform:
+		d = t.A + time.Duration(r.Int63n(int64(t.B-t.A)+1))
+	case ThinkNormal:
+		d = t.A + time.Duration(r.NormFloat64()*float64(t.B))
+	case ThinkExponential:
+		d = time.Duration(r.ExpFloat64() * float64(t.A))
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import "sync"
+
+// Reasons a run ends, recorded in Summary.Ended.
+const (
+	// EndRequests is the end of a run that made its N requests.
+	EndRequests = "requests"
+	// EndDuration is the end of a run that lasted its Duration.
+	EndDuration = "duration"
+	// EndIterations is the end of a run whose cells all made their
+	// CellIterations requests.
+	EndIterations = "iterations"
+	// EndBytes is the end of a run whose responses carried MaxBytes.
+	EndBytes = "bytes"
+	// EndStages and EndSearch are the ends of a run whose stages or
+	// search are over.
+	EndStages = "stages"
+	EndSearch = "search"
+	// EndAborted is the end of a run a stop condition aborted, the
+	// summary carries the condition in StopReason.
+	EndAborted = "aborted"
+	// EndStopped is the end of a run stopped from outside, by a signal or
+	// the control API.
+	EndStopped = "stopped"
+)
+
+// work hands out the request indices [0, n) of a run, n <= 0 for no limit.
+// Cells claim them in order, so every index goes to exactly one cell
+// whatever the number of cells and however it changes. It also counts the
+// bytes of the responses against maxBytes, 0 for no limit.
+type work struct {
+	sync.Mutex
+	next     int
+	n        int
+	bytes    int64
+	maxBytes int64
+	// ended is why no more indices are handed out, empty until then.
+	ended    string
+}
+
+func newWork(n int, maxBytes int64) *work {
+	return &work{n: n, maxBytes: maxBytes}
+}
+
+// claim takes up to k indices, it returns [from, to) and false once no
+// more indices are handed out.
+func (w *work) claim(k int) (from, to int, ok bool) {
+	if k < 1 {
+		k = 1
+	}
+	w.Lock()
+	defer w.Unlock()
+	if len(w.ended) > 0 {
+		return 0, 0, false
+	}
+	if w.n > 0 && w.next >= w.n {
+		w.ended = EndRequests
+		return 0, 0, false
+	}
+	from = w.next
+	w.next = from + k
+	if w.n > 0 {
+		w.next = min(w.n, w.next)
+	}
+	return from, w.next, true
+}
+
+// done counts the bytes of a response, it returns false once they reach
+// maxBytes and the cells must stop. An unknown size, below 0, counts as 0.
+func (w *work) done(size int64) bool {
+	w.Lock()
+	defer w.Unlock()
+	if size > 0 {
+		w.bytes += size
+	}
+	if w.maxBytes > 0 && w.bytes >= w.maxBytes && len(w.ended) == 0 {
+		w.ended = EndBytes
+	}
+	return w.ended != EndBytes
+}
+
+// size returns the bytes of the responses so far.
+func (w *work) size() int64 {
+	w.Lock()
+	defer w.Unlock()
+	return w.bytes
+}
+
+// end returns why the cells ran out of work, if they did.
+func (w *work) end() string {
+	w.Lock()
+	defer w.Unlock()
+	return w.ended
+}
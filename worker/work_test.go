@@ -0,0 +1,116 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestWorkClaim(t *testing.T) {
+	type claim struct {
+		k        int
+		from, to int
+		ok       bool
+	}
+	tests := []struct {
+		name   string
+		n      int
+		claims []claim
+	}{
+		{"one by one", 2, []claim{{1, 0, 1, true}, {1, 1, 2, true}, {1, 0, 0, false}}},
+		{"batch cut at n", 5, []claim{{3, 0, 3, true}, {3, 3, 5, true}, {3, 0, 0, false}}},
+		{"batch below 1", 2, []claim{{0, 0, 1, true}, {-1, 1, 2, true}}},
+		{"no limit", 0, []claim{{100, 0, 100, true}, {1, 100, 101, true}}},
+	}
+	for _, tt := range tests {
+		w := newWork(tt.n, 0)
+		for i, c := range tt.claims {
+			from, to, ok := w.claim(c.k)
+			if from != c.from || to != c.to || ok != c.ok {
+				t.Errorf("%s: claim %d of %d = [%d, %d) %v, want [%d, %d) %v", tt.name, i, c.k,
+					from, to, ok, c.from, c.to, c.ok)
+			}
+		}
+	}
+}
+
+func TestWorkEnd(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int
+		maxBytes int64
+		sizes    []int64
+		// claims is how many single claims succeed afterwards.
+		claims int
+		end    string
+	}{
+		{"requests", 3, 0, nil, 3, EndRequests},
+		{"bytes", 0, 100, []int64{60, 40}, 0, EndBytes},
+		{"bytes not reached", 2, 100, []int64{60, 39}, 2, EndRequests},
+		{"unknown sizes", 2, 100, []int64{-1, -1, 99}, 2, EndRequests},
+	}
+	for _, tt := range tests {
+		w := newWork(tt.n, tt.maxBytes)
+		for i, size := range tt.sizes {
+			last := i == len(tt.sizes)-1
+			if more := w.done(size); more != !(last && tt.end == EndBytes) {
+				t.Errorf("%s: done(%d) = %v", tt.name, size, more)
+			}
+		}
+		claims := 0
+		for ; claims <= tt.n; claims++ {
+			if _, _, ok := w.claim(1); !ok {
+				break
+			}
+		}
+		if claims != tt.claims {
+			t.Errorf("%s: %d claims succeeded, want %d", tt.name, claims, tt.claims)
+		}
+		if end := w.end(); end != tt.end {
+			t.Errorf("%s: end = %q, want %q", tt.name, end, tt.end)
+		}
+	}
+}
+
+func TestWorkClaimConcurrent(t *testing.T) {
+	const n = 1000
+	w := newWork(n, 0)
+	seen := make([]int, n)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for c := 0; c < 30; c++ {
+		wg.Add(1)
+		go func(batch int) {
+			defer wg.Done()
+			for {
+				from, to, ok := w.claim(batch)
+				if !ok {
+					return
+				}
+				mu.Lock()
+				for i := from; i < to; i++ {
+					seen[i]++
+				}
+				mu.Unlock()
+			}
+		}(c%4 + 1)
+	}
+	wg.Wait()
+	for i, count := range seen {
+		if count != 1 {
+			t.Fatalf("index %d was claimed %d times, want once", i, count)
+		}
+	}
+}
@@ -14,7 +14,10 @@
 package worker
 
 import (
+	"context"
 	"io"
+	"math"
+	"math/rand"
 	"time"
 	"sync"
 	"fmt"
@@ -26,33 +29,177 @@ import (
 	"github.com/heidawei/smartBoom/executor"
 )
 
-const maxRes = 10000000
+const (
+	DefaultSigFigs    = 3
+	DefaultMaxLatency = time.Minute
+)
+
+var DefaultPercentiles = []float64{10, 25, 50, 75, 90, 95, 99}
 
 func init() {
 	// do nothing
 }
 
 type Worker struct {
-	// N is the total number of requests to make.
+	// N is the total number of requests to make, 0 for no limit.
 	N int
 
 	// C is the concurrency level, the number of concurrent workers to run.
 	C int
 
-	// Qps is the rate limit in queries per second.
+	// Qps is the rate limit in queries per second of all cells together,
+	// or of every cell on its own if PerCellQPS is set.
 	QPS float64
 
+	// Burst is the number of requests that may go at once after an idle
+	// spell without exceeding QPS, at least 1.
+	Burst int
+
+	PerCellQPS bool
+
+	// Batch is how many request indices a cell claims at once, for
+	// executors making several requests per call, see
+	// executor.BatchExecutor. At least 1.
+	Batch int
+
 	// Sampling interval.
 	Interval time.Duration
 
+	// Duration is the longest the run lasts, 0 for no limit.
+	Duration time.Duration
+
+	// CellIterations is the most requests every cell makes, 0 for no
+	// limit. MaxBytes ends the run once the responses carried that many
+	// bytes, 0 for no limit. The run ends at the first limit it reaches,
+	// see Summary.Ended.
+	CellIterations int
+	MaxBytes       int64
+
 	// Writer is where results will be written. If nil, results are written to stdout.
 	Writer io.Writer
 
+	// SigFigs is the number of significant digits kept by the latency histogram.
+	SigFigs int
+
+	// MaxLatency is the highest latency the histogram can track, larger
+	// latencies are recorded as MaxLatency.
+	MaxLatency time.Duration
+
+	// Percentiles are the latency percentiles reported for every interval.
+	Percentiles []float64
+
 	ExecutorName string
 	Config     map[string]interface{}
 
-	cells   []*Cell
-	output   *OutPut
+	// Outputs are the output specs like "xlsx:path" or "jsonl:-". If empty,
+	// an xlsx file is written next to the executable.
+	Outputs []string
+
+	// LogPath is the file every result is logged to for the report
+	// command, empty disables it.
+	LogPath string
+
+	// Assertions are checked against the summary, and against every
+	// interval if AssertIntervals is set. Run returns ErrAssertionFailed
+	// if one fails.
+	Assertions      []*Assertion
+	AssertIntervals bool
+
+	// JUnitPath is the file the assertion results are written to as JUnit
+	// XML, empty disables it.
+	JUnitPath string
+
+	// StopConditions are checked every interval, the first one met stops
+	// the run and Run returns ErrAborted.
+	StopConditions []*StopCondition
+
+	// Timeout is the deadline of every request, 0 means none. Grace is how
+	// long Stop waits for the requests in flight before it cancels them,
+	// the results of cancelled requests are dropped. The requests of
+	// executors without DoContext are left to finish in the background.
+	Timeout time.Duration
+	Grace   time.Duration
+
+	// ThinkTime is the pause of every cell after each request. Pacing
+	// makes every cell start a request once per period instead, a request
+	// lasting longer is followed by the next one at once.
+	ThinkTime *ThinkTime
+	Pacing    time.Duration
+
+	// Warmup is the first part of the run whose results are written as
+	// warmup intervals and left out of the summary, the assertions, the
+	// stop conditions and the search.
+	Warmup time.Duration
+
+	// Stages is the load plan, the run ends when it is over. The run
+	// starts with C cells.
+	Stages []*Stage
+
+	// OpenModel sends requests on a schedule following the target rate
+	// instead of when a cell is free, and measures their latency from
+	// when they were due. Arrival spaces them uniformly or as a Poisson
+	// process, see ArrivalUniform and ArrivalPoisson.
+	OpenModel bool
+	Arrival   string
+
+	// Search steps the rate or the concurrency to find the highest one
+	// meeting its SLO, the run ends when it is over.
+	Search *Search
+
+	// TUI redraws a dashboard on Writer every interval instead of printing
+	// lines, it falls back to lines if Writer is not a terminal.
+	TUI bool
+
+	// MetricsAddr is the address serving Prometheus metrics on /metrics,
+	// empty disables it.
+	MetricsAddr string
+
+	// ControlAddr is the address serving the Control API, empty disables
+	// it.
+	ControlAddr string
+
+	create   register.CreateExecutor
+	// ctx is cancelled when the grace period of Stop is over.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	// limiter is shared by the cells unless PerCellQPS is set.
+	limiter  *Limiter
+	// arrivals carries the intended starts to the cells in the open model.
+	arrivals chan time.Time
+	// work hands out the N request indices to the cells.
+	work     *work
+	// mu guards the cells and the targets below, which follow the stages,
+	// and the progress read by the control API.
+	mu       sync.Mutex
+	cells    []*Cell
+	// draining are the removed cells until their last results are
+	// collected.
+	draining []*Cell
+	// resized is closed and replaced when the cells change.
+	resized  chan struct{}
+	// closeErrs are the errors closing the executors of drained cells.
+	closeErrs []error
+	nextID   int
+	stopped  bool
+	rate     float64
+	stage    int
+	paused   bool
+	last     *Finalize
+	requests int64
+	control  *Control
+	sink     sinks
+	metrics  *Metrics
+	log      *RawLog
+	intervals int
+	checks   []*AssertionResult
+	stopReason string
+	// ended is why the run ended, guarded by mu.
+	ended    string
+	searched bool
+	err      error
+	total    *interim
+	start    time.Time
+	elapsed  time.Duration
 	stopCh   chan struct{}
 	done     chan struct{}
 	once     sync.Once
@@ -65,99 +212,716 @@ func (b *Worker) writer() io.Writer {
 	return b.Writer
 }
 
-func (b *Worker) Run() {
-	for i := 0; i < b.C; i++ {
-		if e,found := register.GetExecutor(b.ExecutorName); !found {
-			fmt.Println("invalid runner name")
-			os.Exit(-1)
-		} else {
-			exe := e(b.Config)
-			exe.Init()
-			cell := NewCell(b.QPS, exe)
-			b.cells = append(b.cells, cell)
+func (b *Worker) Run() (err error) {
+	e, found := register.GetExecutor(b.ExecutorName)
+	if !found {
+		return fmt.Errorf("invalid runner name %s", b.ExecutorName)
+	}
+	b.create = e
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	defer b.cancel()
+	if b.OpenModel {
+		if len(b.Arrival) == 0 {
+			b.Arrival = ArrivalUniform
+		}
+		if err := checkArrival(b.Arrival); err != nil {
+			return err
+		}
+		if b.QPS <= 0 && len(b.Stages) == 0 {
+			return fmt.Errorf("the open model needs a rate")
+		}
+		b.arrivals = make(chan time.Time)
+	}
+	if setup, found := register.GetSetup(b.ExecutorName); found {
+		if err := setup(b.Config); err != nil {
+			return fmt.Errorf("setup of %s failed, err %v", b.ExecutorName, err)
+		}
+	}
+	defer func() {
+		if e := b.teardown(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	b.rate = -1
+	b.limiter = NewLimiter(b.target(), b.Burst)
+	b.resized = make(chan struct{})
+	b.work = newWork(b.N, b.MaxBytes)
+	if b.SigFigs <= 0 {
+		b.SigFigs = DefaultSigFigs
+	}
+	if b.MaxLatency <= 0 {
+		b.MaxLatency = DefaultMaxLatency
+	}
+	if len(b.Percentiles) == 0 {
+		b.Percentiles = DefaultPercentiles
+	}
+	b.Percentiles = assertionPercentiles(b.Percentiles, b.Assertions)
+	if b.Search != nil {
+		b.Percentiles = assertionPercentiles(b.Percentiles, b.Search.SLO)
+	}
+	for _, c := range b.StopConditions {
+		b.Percentiles = assertionPercentiles(b.Percentiles, []*Assertion{c.Assertion})
+	}
+	if b.AssertIntervals {
+		for _, a := range b.Assertions {
+			if a.perInterval() {
+				b.checks = append(b.checks, &AssertionResult{Assertion: a, Interval: true, Passed: true})
+			}
 		}
 	}
+	b.sink = sinks{b.console()}
+	if len(b.Outputs) == 0 {
+		b.sink = append(b.sink, NewXlsxSink(getCurrentDirectory()))
+	}
+	for _, spec := range b.Outputs {
+		s, err := ParseOutput(spec)
+		if err != nil {
+			return err
+		}
+		b.sink = append(b.sink, s)
+	}
+	b.start = time.Now()
+	if err := b.sink.Open(b.runInfo()); err != nil {
+		return err
+	}
+	if len(b.LogPath) > 0 {
+		l, err := CreateRawLog(b.LogPath, b.runInfo())
+		if err != nil {
+			b.sink.Close()
+			return fmt.Errorf("create result log %s failed, err %v", b.LogPath, err)
+		}
+		b.log = l
+	}
+	if len(b.MetricsAddr) > 0 {
+		b.metrics = NewMetrics(b.ExecutorName)
+		if err := b.metrics.Serve(b.MetricsAddr); err != nil {
+			b.sink.Close()
+			if b.log != nil {
+				b.log.Close()
+			}
+			return fmt.Errorf("serve metrics on %s failed, err %v", b.MetricsAddr, err)
+		}
+	}
+	b.total = b.newInterim()
 	b.done = make(chan struct{})
 	b.stopCh = make(chan struct{})
-	b.output = NewOutPut(getCurrentDirectory())
+	if len(b.ControlAddr) > 0 {
+		b.control = NewControl(b)
+		if err := b.control.Serve(b.ControlAddr); err != nil {
+			b.sink.Close()
+			if b.log != nil {
+				b.log.Close()
+			}
+			if b.metrics != nil {
+				b.metrics.Close()
+			}
+			return fmt.Errorf("serve control api on %s failed, err %v", b.ControlAddr, err)
+		}
+	}
 	// Run the reporter first, it polls the result channel until it is closed.
 	go func() {
 		b.runReporter()
 	}()
+	// Apply the first targets before the cells start, they would send
+	// at full concurrency and rate until the first tick otherwise.
+	switch {
+	case len(b.Stages) > 0:
+		p, _ := stageAt(b.Stages, 0, b.C)
+		b.apply(p)
+	case b.Search != nil:
+		b.applySearch(b.Search.start(b.newInterim()))
+		if b.Search.Dimension == SearchQPS {
+			b.setCells(b.C)
+		}
+	default:
+		b.setCells(b.C)
+	}
+	if b.metrics != nil {
+		b.metrics.setCells(b.activeCells())
+		b.metrics.setQPS(b.targetQPS())
+	}
+	if len(b.Stages) > 0 {
+		go b.runStages()
+	}
+	if b.arrivals != nil {
+		go b.schedule(b.arrivals)
+	}
+	if b.Duration > 0 {
+		go b.limitDuration()
+	}
 	b.runWorkers()
+	// The cells finished on their own, they ran out of work unless every
+	// one made its iterations.
+	if end := b.work.end(); len(end) > 0 {
+		b.setEnded(end)
+	} else {
+		b.setEnded(EndIterations)
+	}
 	b.Finish()
+	return b.err
+}
+
+func (b *Worker) console() Sink {
+	if b.TUI && isTerminal(b.writer()) {
+		return &dashboard{w: b.writer()}
+	}
+	return &consoleSink{w: b.writer()}
 }
 
+// targetQPS returns the rate all cells together aim for, 0 means no limit.
+func (b *Worker) targetQPS() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if rate := b.target(); !math.IsInf(rate, 1) {
+		return rate
+	}
+	return 0
+}
+
+// target returns the rate all cells together aim for, b.mu must be held.
+func (b *Worker) target() float64 {
+	switch {
+	case b.rate >= 0:
+		return b.rate
+	case b.QPS <= 0:
+		return Unlimited
+	case b.PerCellQPS:
+		return b.QPS * float64(len(b.cells))
+	}
+	return b.QPS
+}
+
+// activeCells returns the number of cells sending requests.
+func (b *Worker) activeCells() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.cells)
+}
+
+func (b *Worker) currentStage() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stage
+}
+
+// apply moves the worker to the targets of a stage.
+func (b *Worker) apply(p stagePoint) {
+	b.mu.Lock()
+	b.stage = p.stage
+	b.mu.Unlock()
+	// The rate goes first so that added cells start at the new one.
+	if p.qps >= 0 {
+		b.setRate(p.qps)
+	}
+	b.setCells(p.cells)
+}
+
+// setCells grows or shrinks the cells to n. Removed cells finish the
+// request they are doing and are drained by the reporter.
+func (b *Worker) setCells(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// apply calls this every tick, only a new count wakes the waiters.
+	if b.stopped || len(b.cells) == n {
+		return
+	}
+	var added []*Cell
+	for len(b.cells) < n {
+		added = append(added, b.addCell())
+	}
+	for len(b.cells) > n {
+		c := b.cells[len(b.cells)-1]
+		b.cells = b.cells[:len(b.cells)-1]
+		c.stop()
+		b.draining = append(b.draining, c)
+	}
+	close(b.resized)
+	b.resized = make(chan struct{})
+	// Start the new cells once their limiters follow the target.
+	b.spreadRate()
+	for _, c := range added {
+		go c.run()
+	}
+}
+
+// addCell creates a cell with a new executor, b.mu must be held and the
+// caller starts it. Cells take their requests from the shared b.work until it runs
+// out or they made CellIterations.
+func (b *Worker) addCell() *Cell {
+	exe := b.create(b.Config)
+	exe.Init()
+	limiter := b.limiter
+	if b.PerCellQPS {
+		limiter = NewLimiter(Unlimited, b.Burst)
+	}
+	c := NewCell(limiter, exe)
+	c.ctx, c.timeout = b.ctx, b.Timeout
+	c.id = b.nextID
+	c.schedule = b.arrivals
+	c.work, c.batch, c.iterations = b.work, b.Batch, b.CellIterations
+	// A run bounded by time has no request count, executors see the
+	// largest one as they always did.
+	c.n = b.N
+	if c.n <= 0 {
+		c.n = math.MaxInt32
+	}
+	c.think, c.pacing = b.ThinkTime, b.Pacing
+	c.rand = rand.New(rand.NewSource(time.Now().UnixNano() + int64(c.id)))
+	b.nextID++
+	if b.paused {
+		c.pause()
+	}
+	b.cells = append(b.cells, c)
+	return c
+}
+
+// Pause holds all cells until Resume.
+func (b *Worker) Pause() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paused = true
+	for _, c := range b.cells {
+		c.pause()
+	}
+}
+
+// Resume lets the active cells go on after Pause.
+func (b *Worker) Resume() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paused = false
+	for _, c := range b.cells {
+		c.resume()
+	}
+}
+
+func (b *Worker) isPaused() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.paused
+}
+
+// Status returns the live status of the run.
+func (b *Worker) Status() *Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	planned, maxRequests := b.planned()
+	s := &Status{
+		State:     "running",
+		Elapsed:   time.Since(b.start),
+		Duration:  planned,
+		Requests:  b.requests,
+		Cells:     len(b.cells),
+		Draining:  len(b.draining),
+		Stage:     b.stage,
+		Interval:  b.last,
+	}
+	if rate := b.target(); !math.IsInf(rate, 1) {
+		s.TargetQPS = rate
+	}
+	if b.paused {
+		s.State = "paused"
+	}
+	select {
+	case <-b.stopCh:
+		s.State = "stopping"
+	default:
+	}
+	// The run ends at the first limit, it is as far as the nearest one.
+	if s.Duration > 0 {
+		s.Progress = s.Elapsed.Seconds() / s.Duration.Seconds()
+	}
+	if maxRequests > 0 {
+		s.Progress = math.Max(s.Progress, float64(b.requests)/float64(maxRequests))
+	}
+	if b.MaxBytes > 0 {
+		s.Progress = math.Max(s.Progress, float64(b.work.size())/float64(b.MaxBytes))
+	}
+	s.Progress = math.Min(1, s.Progress)
+	return s
+}
+
+// setRate sets the rate of all cells together.
+func (b *Worker) setRate(qps float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate == qps {
+		return
+	}
+	b.rate = qps
+	b.spreadRate()
+}
+
+// spreadRate retunes the shared limiter to the target rate, or splits it
+// over the active cells if every cell has its own.
+func (b *Worker) spreadRate() {
+	rate := b.target()
+	if !b.PerCellQPS {
+		b.limiter.SetRate(rate)
+		return
+	}
+	if len(b.cells) > 0 {
+		rate /= float64(len(b.cells))
+	}
+	for _, c := range b.cells {
+		c.limiter.SetRate(rate)
+	}
+}
+
+func (b *Worker) runInfo() *RunInfo {
+	info := &RunInfo{
+		ExecutorName: b.ExecutorName,
+		N:            b.N,
+		C:            b.C,
+		QPS:          b.QPS,
+		PerCellQPS:   b.PerCellQPS,
+		Interval:     b.Interval,
+		Duration:     b.Duration,
+		CellIterations: b.CellIterations,
+		MaxBytes:     b.MaxBytes,
+		Percentiles:  b.Percentiles,
+		Config:       b.Config,
+		Start:        b.start,
+		Stages:       stageExprs(b.Stages),
+		OpenModel:    b.OpenModel,
+		Arrival:      b.Arrival,
+		Warmup:       b.Warmup,
+		Pacing:       b.Pacing,
+	}
+	if b.ThinkTime != nil {
+		info.ThinkTime = b.ThinkTime.Expr
+	}
+	info.Planned, info.MaxRequests = b.planned()
+	return info
+}
+
+// planned returns the longest the run lasts and the most requests it
+// makes, the first limit reached ends it. It is 0 for no bound.
+func (b *Worker) planned() (time.Duration, int64) {
+	d := b.Duration
+	var bounds []time.Duration
+	if len(b.Stages) > 0 {
+		bounds = append(bounds, StagesDuration(b.Stages))
+	}
+	if b.Search != nil {
+		bounds = append(bounds, b.Search.planned(b.Interval))
+	}
+	for _, bound := range bounds {
+		if d == 0 || bound < d {
+			d = bound
+		}
+	}
+	n := int64(b.N)
+	// The cells of stages and searches come and go, their iterations
+	// bound nothing.
+	if b.CellIterations > 0 && len(b.Stages) == 0 && b.Search == nil {
+		if it := int64(b.C) * int64(b.CellIterations); n == 0 || it < n {
+			n = it
+		}
+	}
+	return d, n
+}
+
+func (b *Worker) newInterim() *interim {
+	i := NewInterim(b.MaxLatency, b.SigFigs)
+	if b.OpenModel {
+		i.trackUncorrected()
+	}
+	return i
+}
+
+// Stop stops the run from outside, it waits up to Grace for the requests in
+// flight and for the outputs to be written.
 func (b *Worker) Stop() {
+	b.stopFor(EndStopped)
+}
+
+// stopFor stops the run which ended for reason.
+func (b *Worker) stopFor(reason string) {
+	b.setEnded(reason)
 	b.once.Do(func() {
 		// Send stop signal so that workers can stop gracefully.
-		for _, c := range b.cells {
-			c.stop()
-		}
+		b.halt()
+		b.drain()
 		close(b.stopCh)
 		b.finish()
 	})
 }
 
+// drain waits up to Grace for the cells to finish their requests and
+// cancels the ones still in flight.
+func (b *Worker) drain() {
+	b.mu.Lock()
+	cells := append(append([]*Cell(nil), b.cells...), b.draining...)
+	b.mu.Unlock()
+	t := time.NewTimer(b.Grace)
+	defer t.Stop()
+	for _, c := range cells {
+		select {
+		case <-c.done:
+		case <-t.C:
+			b.cancel()
+			return
+		}
+	}
+}
+
+// setEnded records why the run ended, the first reason wins.
+func (b *Worker) setEnded(reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.ended) == 0 {
+		b.ended = reason
+	}
+}
+
+// limitDuration stops the run once it lasted Duration.
+func (b *Worker) limitDuration() {
+	t := time.NewTimer(b.Duration)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		b.stopFor(EndDuration)
+	case <-b.stopCh:
+	}
+}
+
 func (b *Worker) Finish() {
 	b.once.Do(func() {
+		b.halt()
 		close(b.stopCh)
 		b.finish()
 	})
 }
 
+// teardown waits for the cells to close their executors and runs the
+// teardown hook, it returns the first error and prints the others.
+func (b *Worker) teardown() error {
+	b.mu.Lock()
+	cells := append(append([]*Cell(nil), b.cells...), b.draining...)
+	errs := b.closeErrs
+	b.mu.Unlock()
+	for _, c := range cells {
+		<-c.done
+		if c.err != nil {
+			errs = append(errs, c.err)
+		}
+	}
+	if teardown, found := register.GetTeardown(b.ExecutorName); found {
+		if err := teardown(b.Config); err != nil {
+			errs = append(errs, fmt.Errorf("teardown of %s failed, err %v", b.ExecutorName, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	for _, err := range errs[1:] {
+		fmt.Fprintln(b.writer(), err)
+	}
+	return errs[0]
+}
+
+// halt stops all cells and keeps new ones from being added.
+func (b *Worker) halt() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+	for _, c := range b.cells {
+		c.stop()
+	}
+	for _, c := range b.draining {
+		c.stop()
+	}
+}
+
 func (b *Worker) finish() {
 	// Wait until the reporter is done.
 	<-b.done
-	// TODO report
-    b.output.Save()
+	elapsed := b.elapsed - b.Warmup
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	s := b.total.summarize(b.start.Add(b.Warmup), elapsed, b.Percentiles)
+	s.Warmup = b.Warmup
+	s.StopReason = b.stopReason
+	b.mu.Lock()
+	s.Ended = b.ended
+	b.mu.Unlock()
+	if b.Search != nil {
+		s.Search = b.Search.result
+	}
+	if err := b.sink.WriteSummary(s); err != nil {
+		b.err = err
+	}
+	if len(b.stopReason) > 0 && b.err == nil {
+		b.err = ErrAborted
+	}
+	b.assert(s)
+	if err := b.sink.Close(); err != nil && b.err == nil {
+		b.err = err
+	}
+	if b.log != nil {
+		if err := b.log.WriteEnd(&LogEnd{Elapsed: b.elapsed, Ended: s.Ended, StopReason: s.StopReason}); err != nil && b.err == nil {
+			b.err = err
+		}
+		if err := b.log.Close(); err != nil && b.err == nil {
+			b.err = err
+		}
+	}
+	if b.metrics != nil {
+		b.metrics.Close()
+	}
+	if b.control != nil {
+		b.control.Close()
+	}
 }
 
+// assert evaluates the assertions, prints the table and writes the JUnit file.
+func (b *Worker) assert(s *Summary) {
+	if len(b.Assertions) == 0 {
+		return
+	}
+	var rs []*AssertionResult
+	for _, a := range b.Assertions {
+		rs = append(rs, evaluateSummary(a, s))
+	}
+	rs = append(rs, b.checks...)
+	PrintAssertions(b.writer(), rs)
+	if len(b.JUnitPath) > 0 {
+		if err := WriteJUnit(b.JUnitPath, b.ExecutorName, s.Duration, rs); err != nil && b.err == nil {
+			b.err = fmt.Errorf("write junit file %s failed, err %v", b.JUnitPath, err)
+		}
+	}
+	if !AssertionsPassed(rs) && b.err == nil {
+		b.err = ErrAssertionFailed
+	}
+}
+
+// runWorkers waits until every cell made its requests or the run stops,
+// and then until the removed cells are drained. A run without cells goes
+// on until it is stopped.
 func (b *Worker) runWorkers() {
-	var wg sync.WaitGroup
-	wg.Add(b.C)
-	// Ignore the case where b.N % b.C != 0.
-	for i := 0; i < b.C; i++ {
-		go func(index int) {
-			b.cells[index].run(index, b.N/b.C)
-			wg.Done()
-		}(i)
+	for {
+		b.mu.Lock()
+		cells, resized, stopped := b.cells, b.resized, b.stopped
+		b.mu.Unlock()
+		var busy *Cell
+		for _, c := range cells {
+			if !c.finished() {
+				busy = c
+				break
+			}
+		}
+		if busy == nil && (len(cells) > 0 || stopped) {
+			break
+		}
+		var wait <-chan struct{}
+		if busy != nil {
+			wait = busy.done
+		}
+		select {
+		case <-wait:
+		case <-resized:
+		case <-b.stopCh:
+		}
+	}
+	b.mu.Lock()
+	draining := append([]*Cell(nil), b.draining...)
+	b.mu.Unlock()
+	for _, c := range draining {
+		<-c.done
 	}
-	wg.Wait()
+}
+
+// collectable returns the cells to collect from, the drained cells are
+// returned one last time.
+func (b *Worker) collectable() []*Cell {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cells := append([]*Cell(nil), b.cells...)
+	draining := b.draining[:0]
+	for _, c := range b.draining {
+		cells = append(cells, c)
+		if !c.finished() {
+			draining = append(draining, c)
+		} else if c.err != nil {
+			b.closeErrs = append(b.closeErrs, c.err)
+		}
+	}
+	b.draining = draining
+	return cells
 }
 
 func (b *Worker) runReporter() {
-	r := NewInterim()
+	r := b.newInterim()
+	// warm holds the results of requests started during the warmup.
+	warm := b.newInterim()
+	warmupEnd := b.start.Add(b.Warmup)
 	start := now()
-	rss := make([][]*executor.Result, len(b.cells))
-	collector := func(total time.Duration) {
-		for i, cell := range b.cells {
-			rs := cell.reset()
-			rss[i] = rs
+	var rss [][]*executor.Result
+	// final is set for the last collection after the run stopped, the
+	// stop conditions are not checked on it.
+	collector := func(total time.Duration, final bool) {
+		cells := b.collectable()
+		rss = rss[:0]
+		for _, cell := range cells {
+			rss = append(rss, cell.reset())
 		}
-		for _, rs := range rss {
+		for i, rs := range rss {
 			for _, res := range rs {
-				if res.Count == 0 {
-					r.numRes++
+				if res.Start.Before(warmupEnd) {
+					warm.add(res)
 				} else {
-					r.numRes += int64(res.Count)
+					r.add(res)
 				}
-				if res.Err != nil {
-					r.errCount++
-				} else {
-					r.successCount++
-					r.avgTotal += res.Duration.Seconds()
-					if len(r.lats) < maxRes {
-						r.lats = append(r.lats, res.Duration.Seconds())
-					}
-					if res.ContentLength > 0 {
-						r.sizeTotal += res.ContentLength
-					}
+				if b.metrics != nil {
+					b.metrics.observe(res)
+				}
+				if b.log != nil {
+					// Errors are sticky and reported by Close.
+					b.log.Write(cells[i].id, res)
 				}
 			}
 		}
-        f := r.finalize(total)
-        b.output.Write(f)
+		warming := time.Now().Before(warmupEnd)
+		if warm.numRes > 0 || warming {
+			f := warm.finalize(total, b.Percentiles)
+			f.Warmup = true
+			b.writeInterval(f)
+			warm.reset()
+		}
+		if warming {
+			for _, rs := range rss {
+				executor.PutResultsToPool(rs)
+			}
+			return
+		}
+        f := r.finalize(total, b.Percentiles)
+		b.intervals++
+		for _, c := range b.checks {
+			c.checkInterval(b.intervals, f)
+		}
+		for _, c := range b.StopConditions {
+			if final {
+				break
+			}
+			if reason := c.check(f, total); len(reason) > 0 && len(b.stopReason) == 0 {
+				b.stopReason = reason
+				// Stop waits for the reporter, so it cannot run here.
+				go b.stopFor(EndAborted)
+			}
+		}
+		if b.Search != nil && !b.searched {
+			next, over, done := b.Search.add(r, total, b.Percentiles)
+			if done {
+				b.searched = true
+				go b.stopFor(EndSearch)
+			} else if over {
+				b.applySearch(next)
+			}
+		}
+		b.writeInterval(f)
+		b.total.merge(r)
 		r.reset()
 		for _, rs := range rss {
 			executor.PutResultsToPool(rs)
@@ -166,54 +930,239 @@ func (b *Worker) runReporter() {
 	for {
 		select {
 		case <-b.stopCh:
-			collector(now() - start)
+			collector(now() - start, true)
+			b.elapsed = time.Since(b.start)
 		    close(b.done)
 			return
 		case <-time.After(b.Interval):
-			collector(now() - start)
+			collector(now() - start, false)
 		    start = now()
 		}
 	}
 }
 
+// writeInterval fills in the targets of f and writes it to the sinks.
+func (b *Worker) writeInterval(f *Finalize) {
+	f.Cells = b.activeCells()
+	f.TargetQPS = b.targetQPS()
+	f.Stage = b.currentStage()
+	if b.metrics != nil {
+		b.metrics.setCells(f.Cells)
+		b.metrics.setQPS(f.TargetQPS)
+	}
+	if err := b.sink.WriteInterval(f); err != nil {
+		fmt.Fprintln(b.writer(), "write output failed ", err)
+	}
+	b.mu.Lock()
+	b.last = f
+	b.requests += f.Requests
+	b.mu.Unlock()
+}
+
 type Cell struct {
 	sync.Mutex
-	qps      float64
+	id       int
+	limiter  *Limiter
 	stopCh   chan struct{}
-	runner   executor.Executor
+	runner   executor.ContextExecutor
+	// batcher is the runner if it makes several requests per call.
+	batcher  executor.BatchExecutor
+	// ctx is the context of the run, every request gets timeout on top.
+	ctx      context.Context
+	timeout  time.Duration
 	results  []*executor.Result
+	// paused is closed and replaced when the cell resumes.
+	paused   chan struct{}
+	// schedule delivers the intended starts in the open model, the
+	// limiter is not used if it is set.
+	schedule <-chan time.Time
+	// work hands out the request indices, the cell claims batch at once
+	// and uses them all before it claims more.
+	work     *work
+	batch    int
+	// n is the requests of the run passed to the executor.
+	n        int
+	// iterations is the most requests the cell makes, 0 for no limit.
+	iterations int
+	stopped  bool
+	// closer closes the executor when run returns, err is the error.
+	closer   executor.Closer
+	err      error
+	// done is closed when run returns.
+	done     chan struct{}
+	// think is the pause after every request, pacing the period of every
+	// request instead if it is set. Neither counts in the latency.
+	think    *ThinkTime
+	pacing   time.Duration
+	rand     *rand.Rand
 }
 
-func NewCell(qps float64, runner executor.Executor) *Cell {
-	return &Cell{qps: qps, stopCh: make(chan struct{}), runner: runner, results: executor.GetResultsFromPool()}
+func NewCell(limiter *Limiter, runner executor.Executor) *Cell {
+	c := &Cell{limiter: limiter, stopCh: make(chan struct{}), runner: executor.WithContext(runner),
+		ctx: context.Background(), results: executor.GetResultsFromPool(), done: make(chan struct{})}
+	c.closer, _ = c.runner.(executor.Closer)
+	c.batcher, _ = runner.(executor.BatchExecutor)
+	return c
 }
 
-func (c *Cell) run(base, n int) {
-	var throttle <-chan time.Time
-	if c.qps > 0 {
-		throttle = time.Tick(time.Duration(1e6/(c.qps)) * time.Microsecond)
-	}
-
-	for i := 0; i < n; {
+func (c *Cell) run() {
+	defer close(c.done)
+	defer c.close()
+	var index, end, made int
+	for {
 		// Check if application is stopped. Do not send into a closed channel.
 		select {
 		case <-c.stopCh:
 			return
 		default:
-			if c.qps > 0 {
-				<-throttle
+			if !c.wait() {
+				return
+			}
+			var due time.Time
+			if c.schedule != nil {
+				select {
+				case due = <-c.schedule:
+				case <-c.stopCh:
+					return
+				}
+			} else if !c.limiter.Wait(c.stopCh) {
+				return
+			}
+			if index == end {
+				var ok bool
+				k := c.batch
+				if c.iterations > 0 {
+					k = min(k, c.iterations-made)
+				}
+				if index, end, ok = c.work.claim(k); !ok {
+					return
+				}
+			}
+			start := time.Now()
+			res := c.do(index, end)
+			if c.ctx.Err() != nil {
+				// Stop cancelled the request.
+				return
 			}
-			res := c.runner.Do(base, i, n)
-			i += res.Count
+			res.Start = start
+			if c.schedule != nil && start.After(due) {
+				// Count the time the request waited for this cell.
+				res.Start = due
+				res.Delay = start.Sub(due)
+				res.Duration += res.Delay
+			}
+			// An executor making more than it claimed still counts in
+			// full, the rest of the claim is not used.
+			count := max(1, res.Count)
+			index = min(end, index+count)
+			made += count
 			c.Lock()
 			c.results = append(c.results, res)
 			c.Unlock()
+			if !c.work.done(res.ContentLength) || (c.iterations > 0 && made >= c.iterations) {
+				return
+			}
+			if !c.rest(start) {
+				return
+			}
 		}
 	}
 }
 
+func (c *Cell) close() {
+	if c.closer == nil {
+		return
+	}
+	if err := c.closer.Close(); err != nil {
+		c.err = fmt.Errorf("close executor of cell %d failed, err %v", c.id, err)
+	}
+}
+
+// do makes the request index, or up to the claimed end with a batcher.
+func (c *Cell) do(index, end int) *executor.Result {
+	ctx := c.ctx
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(c.ctx, c.timeout)
+		defer cancel()
+	}
+	if c.batcher != nil {
+		return c.batcher.DoBatch(ctx, c.id, index, end, c.n)
+	}
+	return c.runner.DoContext(ctx, c.id, index, c.n)
+}
+
+// rest sleeps the think time, or until the next period with pacing, after
+// a request that began at began. It returns false if the cell stops.
+func (c *Cell) rest(began time.Time) bool {
+	var d time.Duration
+	if c.pacing > 0 {
+		d = time.Until(began.Add(c.pacing))
+	} else if c.think != nil {
+		d = c.think.next(c.rand)
+	}
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	select {
+	case <-t.C:
+		return true
+	case <-c.stopCh:
+		t.Stop()
+		return false
+	}
+}
+
+// wait blocks while the cell is paused, it returns false if the cell stops.
+func (c *Cell) wait() bool {
+	c.Lock()
+	paused := c.paused
+	c.Unlock()
+	if paused == nil {
+		return true
+	}
+	select {
+	case <-paused:
+		return true
+	case <-c.stopCh:
+		return false
+	}
+}
+
+func (c *Cell) pause() {
+	c.Lock()
+	defer c.Unlock()
+	if c.paused == nil {
+		c.paused = make(chan struct{})
+	}
+}
+
+func (c *Cell) resume() {
+	c.Lock()
+	defer c.Unlock()
+	if c.paused != nil {
+		close(c.paused)
+		c.paused = nil
+	}
+}
+
 func (c *Cell) stop() {
-	close(c.stopCh)
+	c.Lock()
+	defer c.Unlock()
+	if !c.stopped {
+		c.stopped = true
+		close(c.stopCh)
+	}
+}
+
+func (c *Cell) finished() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
 }
 
 func (c *Cell) reset() []*executor.Result {
@@ -0,0 +1,445 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/heidawei/smartBoom/executor"
+	"github.com/heidawei/smartBoom/register"
+)
+
+// fastExecutor answers at once, a run is bounded by its limits only.
+type fastExecutor struct{}
+
+func (fastExecutor) Init() {}
+
+func (fastExecutor) Do(base, index, n int) *executor.Result {
+	return &executor.Result{StatusCode: 200, Count: 1}
+}
+
+// sleepExecutor takes the "sleep" duration of its config, or 1 to 3ms
+// without one so that a run gets a spread of latencies.
+type sleepExecutor struct {
+	d time.Duration
+}
+
+func (sleepExecutor) Init() {}
+
+func (e sleepExecutor) Do(base, index, n int) *executor.Result {
+	start := time.Now()
+	d := e.d
+	if d == 0 {
+		d = time.Duration(index%3+1) * time.Millisecond
+	}
+	time.Sleep(d)
+	return &executor.Result{StatusCode: 200, Count: 1, Duration: time.Since(start), ContentLength: 10}
+}
+
+// loadExecutor takes 5ms for every request in flight, its latency grows
+// with the concurrency.
+type loadExecutor struct{}
+
+var inFlight int32
+
+func (loadExecutor) Init() {}
+
+func (loadExecutor) Do(base, index, n int) *executor.Result {
+	start := time.Now()
+	k := atomic.AddInt32(&inFlight, 1)
+	defer atomic.AddInt32(&inFlight, -1)
+	time.Sleep(time.Duration(k) * 5 * time.Millisecond)
+	return &executor.Result{StatusCode: 200, Count: 1, Duration: time.Since(start)}
+}
+
+// batchExecutor makes all the requests it claimed in one call, it fails
+// if the claim or the count of the run is not the one of TestWorkerBatch.
+type batchExecutor struct{}
+
+func (batchExecutor) Init() {}
+
+func (batchExecutor) Do(base, index, n int) *executor.Result {
+	return &executor.Result{Err: errors.New("Do called on a batch executor"), Count: 1}
+}
+
+func (batchExecutor) DoBatch(ctx context.Context, base, index, end, n int) *executor.Result {
+	if end-index < 1 || end-index > 4 || n != 10 {
+		return &executor.Result{Err: fmt.Errorf("claim [%d, %d) of %d", index, end, n), Count: 1}
+	}
+	return &executor.Result{StatusCode: 200, Count: end - index}
+}
+
+// hookExecutor counts its setups, teardowns and closes, its setup fails
+// if the config has "fail-setup" and its closes if it has "fail-close".
+type hookExecutor struct {
+	config map[string]interface{}
+}
+
+var setups, teardowns, closes int32
+
+func (hookExecutor) Init() {}
+
+func (hookExecutor) Do(base, index, n int) *executor.Result {
+	return &executor.Result{StatusCode: 200, Count: 1}
+}
+
+func (e hookExecutor) Close() error {
+	atomic.AddInt32(&closes, 1)
+	if e.config["fail-close"] != nil {
+		return errors.New("close failed")
+	}
+	return nil
+}
+
+// blockExecutor has no DoContext and hangs until release is closed.
+type blockExecutor struct {
+	release chan struct{}
+}
+
+// release is the channel of the block executors of the next run.
+var release chan struct{}
+
+func (blockExecutor) Init() {}
+
+func (e blockExecutor) Do(base, index, n int) *executor.Result {
+	<-e.release
+	return &executor.Result{StatusCode: 200, Count: 1}
+}
+
+// slowExecutor takes a second unless its context is done first.
+type slowExecutor struct{}
+
+func (slowExecutor) Init() {}
+
+func (slowExecutor) Do(base, index, n int) *executor.Result {
+	return slowExecutor{}.DoContext(context.Background(), base, index, n)
+}
+
+func (slowExecutor) DoContext(ctx context.Context, base, index, n int) *executor.Result {
+	select {
+	case <-time.After(time.Second):
+		return &executor.Result{StatusCode: 200, Count: 1}
+	case <-ctx.Done():
+		return &executor.Result{Err: ctx.Err(), Count: 1}
+	}
+}
+
+func init() {
+	register.RegisterExecutor("test-fast", func(map[string]interface{}) executor.Executor {
+		return fastExecutor{}
+	})
+	register.RegisterExecutor("test-block", func(map[string]interface{}) executor.Executor {
+		return blockExecutor{release}
+	})
+	register.RegisterExecutor("test-sleep", func(config map[string]interface{}) executor.Executor {
+		d, _ := config["sleep"].(time.Duration)
+		return sleepExecutor{d}
+	})
+	register.RegisterExecutor("test-load", func(map[string]interface{}) executor.Executor {
+		return loadExecutor{}
+	})
+	register.RegisterExecutor("test-hooks", func(config map[string]interface{}) executor.Executor {
+		return hookExecutor{config}
+	})
+	register.RegisterSetup("test-hooks", func(config map[string]interface{}) error {
+		atomic.AddInt32(&setups, 1)
+		if config["fail-setup"] != nil {
+			return errors.New("setup failed")
+		}
+		return nil
+	})
+	register.RegisterTeardown("test-hooks", func(map[string]interface{}) error {
+		atomic.AddInt32(&teardowns, 1)
+		return nil
+	})
+	register.RegisterExecutor("test-batch", func(map[string]interface{}) executor.Executor {
+		return batchExecutor{}
+	})
+	register.RegisterExecutor("test-slow", func(map[string]interface{}) executor.Executor {
+		return slowExecutor{}
+	})
+}
+
+// runTest runs b with the fast executor and returns the requests made.
+func runTest(t *testing.T, b *Worker) int64 {
+	dir, err := ioutil.TempDir("", "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if len(b.ExecutorName) == 0 {
+		b.ExecutorName = "test-fast"
+	}
+	b.Interval = 100 * time.Millisecond
+	b.Writer = ioutil.Discard
+	b.Outputs = []string{"jsonl:" + path.Join(dir, "out.jsonl")}
+	if err := b.Run(); err != nil {
+		t.Fatalf("Run failed, err %v", err)
+	}
+	return b.total.numRes
+}
+
+func TestWorkerRateFromStart(t *testing.T) {
+	tests := []struct {
+		name       string
+		qps        float64
+		perCellQPS bool
+		// max is the most requests in 300ms, a burst at an unlimited
+		// rate makes many thousands.
+		max int64
+	}{
+		{"shared", 50, false, 30},
+		{"per cell", 1, true, 2 * 20},
+	}
+	for _, tt := range tests {
+		b := &Worker{C: 20, QPS: tt.qps, PerCellQPS: tt.perCellQPS, Duration: 300 * time.Millisecond}
+		if n := runTest(t, b); n > tt.max {
+			t.Errorf("%s: %d requests in 300ms at %vqps, want at most %d", tt.name, n, tt.qps, tt.max)
+		}
+	}
+}
+
+func TestWorkerFirstStage(t *testing.T) {
+	stages, err := ParseStages("300ms@10qps")
+	if err != nil {
+		t.Fatalf("ParseStages failed, err %v", err)
+	}
+	b := &Worker{C: 50, Stages: stages}
+	// 3 requests in 300ms plus the burst, the first tick comes too late
+	// to hold back 50 cells at an unlimited rate.
+	if n := runTest(t, b); n > 10 {
+		t.Errorf("%d requests in a 300ms stage at 10qps, want at most 10", n)
+	}
+	if b.ended != EndStages {
+		t.Errorf("the run ended for %q, want %q", b.ended, EndStages)
+	}
+}
+
+func TestWorkerRequests(t *testing.T) {
+	tests := []struct {
+		n, c  int
+		batch int
+	}{
+		{1000, 300, 1},
+		{1001, 7, 3},
+		{10, 10, 4},
+	}
+	for _, tt := range tests {
+		b := &Worker{N: tt.n, C: tt.c, Batch: tt.batch}
+		if got := runTest(t, b); got != int64(tt.n) {
+			t.Errorf("-n %d -c %d -batch %d made %d requests", tt.n, tt.c, tt.batch, got)
+		}
+		if b.ended != EndRequests {
+			t.Errorf("-n %d -c %d ended for %q, want %q", tt.n, tt.c, b.ended, EndRequests)
+		}
+	}
+}
+
+func TestWorkerBatch(t *testing.T) {
+	b := &Worker{ExecutorName: "test-batch", N: 10, C: 2, Batch: 4}
+	if n := runTest(t, b); n != 10 {
+		t.Errorf("%d requests made, want 10", n)
+	}
+	if len(b.total.errs) > 0 {
+		t.Errorf("the executor got wrong arguments: %v", b.total.errs)
+	}
+}
+
+func TestWorkerStopBlocked(t *testing.T) {
+	release = make(chan struct{})
+	defer close(release)
+	b := &Worker{ExecutorName: "test-block", C: 3, Grace: 50 * time.Millisecond}
+	time.AfterFunc(100*time.Millisecond, b.Stop)
+	done := make(chan int64)
+	go func() {
+		done <- runTest(t, b)
+	}()
+	select {
+	case n := <-done:
+		if n != 0 {
+			t.Errorf("%d requests of a blocked executor were counted, want 0", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Stop did not end a run whose executor blocks")
+	}
+}
+
+func TestWorkerTimeout(t *testing.T) {
+	b := &Worker{ExecutorName: "test-slow", N: 4, C: 2, Timeout: 20 * time.Millisecond}
+	if n := runTest(t, b); n != 4 {
+		t.Fatalf("%d requests made, want 4", n)
+	}
+	msg := context.DeadlineExceeded.Error()
+	if got := b.total.errs[msg]; got != 4 {
+		t.Errorf("%d requests failed with %q, want 4, errors %v", got, msg, b.total.errs)
+	}
+}
+
+func TestWorkerStopGrace(t *testing.T) {
+	b := &Worker{ExecutorName: "test-slow", C: 2, Grace: 50 * time.Millisecond}
+	time.AfterFunc(50*time.Millisecond, b.Stop)
+	start := time.Now()
+	runTest(t, b)
+	// The requests in flight take a second, Stop cancels them after Grace.
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("the run took %s after Stop with a grace of %s, want under 500ms", d, b.Grace)
+	}
+	if b.ended != EndStopped {
+		t.Errorf("the run ended for %q, want %q", b.ended, EndStopped)
+	}
+}
+
+func TestWorkerOpenModel(t *testing.T) {
+	// One cell taking 20ms falls behind a request due every 10ms.
+	b := &Worker{ExecutorName: "test-sleep", Config: map[string]interface{}{"sleep": 20 * time.Millisecond},
+		C: 1, QPS: 100, OpenModel: true, Duration: 500 * time.Millisecond}
+	runTest(t, b)
+	corrected := latencies(b.total.hist, []float64{50})[0].Latency
+	uncorrected := latencies(b.total.uncorrected, []float64{50})[0].Latency
+	if uncorrected < 0.02 || corrected < 2*uncorrected {
+		t.Errorf("p50 %vs from the intended starts, %vs from the actual ones, want the first well above",
+			corrected, uncorrected)
+	}
+}
+
+func TestWorkerSearch(t *testing.T) {
+	// The cells drained after a step end their last requests in the
+	// next one, the median leaves them out.
+	slo, err := ParseAssertion("p50<12ms")
+	if err != nil {
+		t.Fatalf("ParseAssertion failed, err %v", err)
+	}
+	for _, binary := range []bool{false, true} {
+		search, err := NewSearch(SearchCells, "1:8", 1, binary, 200*time.Millisecond, []*Assertion{slo})
+		if err != nil {
+			t.Fatalf("NewSearch failed, err %v", err)
+		}
+		b := &Worker{ExecutorName: "test-load", C: 1, Search: search}
+		runTest(t, b)
+		// 2 cells take 10ms, 3 take 15ms.
+		if max := search.result.Max; max != 2 {
+			t.Errorf("binary %v: the search found %v cells, want 2, steps %d", binary, max, len(search.result.Steps))
+		}
+		if b.ended != EndSearch {
+			t.Errorf("binary %v: the run ended for %q, want %q", binary, b.ended, EndSearch)
+		}
+	}
+}
+
+func TestWorkerWarmup(t *testing.T) {
+	// 50 requests at 100qps, the first 20 during the warmup.
+	a, err := ParseAssertion("requests<=40")
+	if err != nil {
+		t.Fatalf("ParseAssertion failed, err %v", err)
+	}
+	b := &Worker{C: 1, QPS: 100, Warmup: 200 * time.Millisecond, Duration: 500 * time.Millisecond,
+		Assertions: []*Assertion{a}}
+	measured := runTest(t, b)
+	if b.requests <= measured || measured < 20 || measured > 40 {
+		t.Errorf("%d requests measured of %d, want about 30", measured, b.requests)
+	}
+}
+
+func TestWorkerThinkTime(t *testing.T) {
+	think, err := ParseThinkTime("constant:50ms")
+	if err != nil {
+		t.Fatalf("ParseThinkTime failed, err %v", err)
+	}
+	tests := []struct {
+		name     string
+		b        *Worker
+		min, max int64
+	}{
+		// A request every 50ms for 300ms.
+		{"think time", &Worker{ThinkTime: think}, 4, 7},
+		{"pacing", &Worker{Pacing: 100 * time.Millisecond}, 2, 4},
+		// Pacing starts the next request at once after a slow one.
+		{"pacing behind", &Worker{ExecutorName: "test-sleep", Config: map[string]interface{}{"sleep": 60 * time.Millisecond},
+			Pacing: 20 * time.Millisecond}, 4, 6},
+	}
+	for _, tt := range tests {
+		tt.b.C, tt.b.Duration = 1, 300*time.Millisecond
+		if n := runTest(t, tt.b); n < tt.min || n > tt.max {
+			t.Errorf("%s: %d requests in 300ms, want %d to %d", tt.name, n, tt.min, tt.max)
+		}
+		// The pause is not part of the latency.
+		if max := latencies(tt.b.total.hist, []float64{100})[0].Latency; max > 0.07 {
+			t.Errorf("%s: max latency %vs includes the pause", tt.name, max)
+		}
+	}
+}
+
+func TestWorkerHooks(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		// err is in the error of Run, empty if it succeeds.
+		err                       string
+		setups, teardowns, closes int32
+	}{
+		{"run", nil, "", 1, 1, 3},
+		{"setup fails", map[string]interface{}{"fail-setup": true}, "setup failed", 1, 0, 0},
+		{"close fails", map[string]interface{}{"fail-close": true}, "close failed", 1, 1, 3},
+	}
+	for _, tt := range tests {
+		setups, teardowns, closes = 0, 0, 0
+		dir, err := ioutil.TempDir("", "worker")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &Worker{ExecutorName: "test-hooks", Config: tt.config, N: 30, C: 3, Interval: 100 * time.Millisecond,
+			Writer: ioutil.Discard, Outputs: []string{"jsonl:" + path.Join(dir, "out.jsonl")}}
+		err = b.Run()
+		os.RemoveAll(dir)
+		if len(tt.err) == 0 && err != nil || len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: Run returned %v, want %q", tt.name, err, tt.err)
+		}
+		if setups != tt.setups || teardowns != tt.teardowns || closes != tt.closes {
+			t.Errorf("%s: %d setups, %d teardowns, %d closes, want %d, %d, %d", tt.name, setups, teardowns, closes,
+				tt.setups, tt.teardowns, tt.closes)
+		}
+	}
+}
+
+func TestWorkerLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		b        *Worker
+		min, max int64
+		end      string
+	}{
+		{"iterations", &Worker{C: 3, CellIterations: 5}, 15, 15, EndIterations},
+		{"requests before iterations", &Worker{N: 10, C: 3, CellIterations: 5}, 10, 10, EndRequests},
+		// Every request gets 10 bytes, the cells in flight finish theirs.
+		{"bytes", &Worker{ExecutorName: "test-sleep", C: 2, MaxBytes: 100}, 10, 11, EndBytes},
+		{"duration", &Worker{C: 1, QPS: 100, Duration: 200 * time.Millisecond, N: 1000}, 15, 25, EndDuration},
+	}
+	for _, tt := range tests {
+		if n := runTest(t, tt.b); n < tt.min || n > tt.max {
+			t.Errorf("%s: %d requests made, want %d to %d", tt.name, n, tt.min, tt.max)
+		}
+		if tt.b.ended != tt.end {
+			t.Errorf("%s: the run ended for %q, want %q", tt.name, tt.b.ended, tt.end)
+		}
+	}
+}
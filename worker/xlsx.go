@@ -0,0 +1,216 @@
+// Copyright 2018 The hedawei Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tealeg/xlsx"
+)
+
+var Titles = []string{"timestamp", "TPS", "avg latency", "total success", "total fail"}
+
+// titles returns the header row, one column per percentile after Titles,
+// one per uncorrected percentile in the open model and a stage column if
+// the run follows stages.
+func titles(info *RunInfo) []string {
+	ts := append([]string(nil), Titles...)
+	for _, p := range info.Percentiles {
+		ts = append(ts, PercentileName(p))
+	}
+	if info.OpenModel {
+		for _, p := range info.Percentiles {
+			ts = append(ts, "uncorrected "+PercentileName(p))
+		}
+	}
+	if len(info.Stages) > 0 {
+		ts = append(ts, "stage")
+	}
+	return ts
+}
+
+func init() {
+	RegisterSink("xlsx", NewXlsxSink)
+}
+
+// XlsxSink writes intervals to a "statis" sheet and the summary to a
+// "summary" sheet. If path is empty or a directory, the file is named
+// output_<start time>.xlsx inside it.
+type XlsxSink struct {
+	path string
+	staged bool
+	warmup bool
+	f    *xlsx.File
+	sheet *xlsx.Sheet
+	options xlsx.DateTimeOptions
+}
+
+func NewXlsxSink(path string) Sink {
+	return &XlsxSink{path: path}
+}
+
+func (o *XlsxSink) Open(info *RunInfo) error {
+	o.f = xlsx.NewFile()
+	sheet, err := o.f.AddSheet("statis")
+	if err != nil {
+		return fmt.Errorf("xlsx add sheet failed, err %v", err)
+	}
+	// title
+	r := sheet.AddRow()
+	for _, title := range titles(info) {
+		cell := r.AddCell()
+		cell.Value = title
+	}
+	o.warmup = info.Warmup > 0
+	if o.warmup {
+		r.AddCell().Value = "warmup"
+	}
+	o.sheet = sheet
+	o.staged = len(info.Stages) > 0
+	l, _ := time.LoadLocation("Local")
+	o.options = xlsx.DateTimeOptions{Location: l, ExcelTimeFormat: "h:mm:ss"}
+	if fi, err := os.Stat(o.path); len(o.path) == 0 || (err == nil && fi.IsDir()) {
+		o.path = path.Join(o.path, fmt.Sprintf("output_%s.xlsx", info.Start.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+func (o *XlsxSink) WriteInterval(f *Finalize) error {
+	r := o.sheet.AddRow()
+
+	// timestamp
+	cell := r.AddCell()
+	cell.SetDateWithOptions(f.TimeStamp, o.options)
+	// TPS
+	cell = r.AddCell()
+	cell.SetFloat(f.TPS)
+	// avg
+	cell = r.AddCell()
+	cell.SetFloat(f.AvgDelay)
+	// success
+	cell = r.AddCell()
+	cell.SetInt64(f.Success)
+	// fail
+	cell = r.AddCell()
+	cell.SetInt64(f.Err)
+	for _, lat := range f.Percentiles {
+		cell = r.AddCell()
+		cell.SetFloat(lat.Latency)
+	}
+	for _, lat := range f.Uncorrected {
+		cell = r.AddCell()
+		cell.SetFloat(lat.Latency)
+	}
+	if o.staged {
+		cell = r.AddCell()
+		cell.SetInt(f.Stage)
+	}
+	if o.warmup {
+		cell = r.AddCell()
+		cell.SetBool(f.Warmup)
+	}
+	return nil
+}
+
+// WriteSummary adds a "summary" sheet with the whole run aggregate.
+func (o *XlsxSink) WriteSummary(s *Summary) error {
+	sheet, err := o.f.AddSheet("summary")
+	if err != nil {
+		return fmt.Errorf("xlsx add sheet failed, err %v", err)
+	}
+	addRow := func(name string) *xlsx.Row {
+		r := sheet.AddRow()
+		r.AddCell().SetString(name)
+		return r
+	}
+	addRow("start").AddCell().SetDateWithOptions(s.Start, o.options)
+	if s.Warmup > 0 {
+		addRow("warmup").AddCell().SetFloat(s.Warmup.Seconds())
+	}
+	if len(s.Ended) > 0 {
+		addRow("ended").AddCell().SetString(s.Ended)
+	}
+	if len(s.StopReason) > 0 {
+		addRow("stop reason").AddCell().SetString(s.StopReason)
+	}
+	addRow("duration").AddCell().SetFloat(s.Duration.Seconds())
+	addRow("requests").AddCell().SetInt64(s.Requests)
+	addRow("success").AddCell().SetInt64(s.Success)
+	addRow("errors").AddCell().SetInt64(s.Err)
+	addRow("RPS").AddCell().SetFloat(s.RPS)
+	addRow("min latency").AddCell().SetFloat(s.Min)
+	addRow("avg latency").AddCell().SetFloat(s.Mean)
+	addRow("stddev latency").AddCell().SetFloat(s.StdDev)
+	addRow("max latency").AddCell().SetFloat(s.Max)
+	for _, lat := range s.Percentiles {
+		addRow(PercentileName(lat.Percentage)).AddCell().SetFloat(lat.Latency)
+	}
+	for _, lat := range s.Uncorrected {
+		addRow("uncorrected " + PercentileName(lat.Percentage)).AddCell().SetFloat(lat.Latency)
+	}
+	addRow("total size").AddCell().SetInt64(s.Size)
+	for _, code := range s.Codes() {
+		addRow(fmt.Sprintf("status %d", code)).AddCell().SetInt64(s.StatusCodes[code])
+	}
+	if s.Search != nil {
+		addRow("max " + s.Search.Dimension).AddCell().SetFloat(s.Search.Max)
+		return o.writeSearch(s.Search)
+	}
+	return nil
+}
+
+// writeSearch adds a "search" sheet with one row per step.
+func (o *XlsxSink) writeSearch(r *SearchResult) error {
+	sheet, err := o.f.AddSheet("search")
+	if err != nil {
+		return fmt.Errorf("xlsx add sheet failed, err %v", err)
+	}
+	row := sheet.AddRow()
+	for _, title := range []string{"target " + r.Dimension, "duration", "RPS", "requests", "errors"} {
+		row.AddCell().SetString(title)
+	}
+	if len(r.Steps) > 0 {
+		for _, lat := range r.Steps[0].Percentiles {
+			row.AddCell().SetString(PercentileName(lat.Percentage))
+		}
+	}
+	row.AddCell().SetString("passed")
+	row.AddCell().SetString("failed")
+	for _, st := range r.Steps {
+		row = sheet.AddRow()
+		row.AddCell().SetFloat(st.Target)
+		row.AddCell().SetFloat(st.Duration.Seconds())
+		row.AddCell().SetFloat(st.RPS)
+		row.AddCell().SetInt64(st.Requests)
+		row.AddCell().SetInt64(st.Err)
+		for _, lat := range st.Percentiles {
+			row.AddCell().SetFloat(lat.Latency)
+		}
+		row.AddCell().SetBool(st.Passed)
+		row.AddCell().SetString(strings.Join(st.Failed, ", "))
+	}
+	return nil
+}
+
+// Close saves the workbook, nothing is written to disk before.
+func (o *XlsxSink) Close() error {
+	if err := o.f.Save(o.path); err != nil {
+		return fmt.Errorf("save output file %s failed, err %v", o.path, err)
+	}
+	return nil
+}